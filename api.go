@@ -2,18 +2,23 @@ package main
 
 import (
 	"database/sql"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
+	"unicode/utf8"
 
 	"github.com/labstack/echo/v4"
 )
 
-//TODO limit info provied based on permissions
-
 var ApiGroup *echo.Group = App.Group("/api")
 
 func getContextIds(c echo.Context) (string, AccountId, error) {
@@ -26,7 +31,7 @@ func getContextIds(c echo.Context) (string, AccountId, error) {
 	var accountId AccountId
 	if err := row.Scan(&accountId); err != nil {
 		if err == sql.ErrNoRows {
-			return "", 0, c.Redirect(http.StatusFound, App.Reverse("Login"))
+			return "", 0, echo.NewHTTPError(http.StatusUnauthorized, "Not logged in.")
 		}
 		c.Logger().Error(err)
 		return "", 0, echo.NewHTTPError(http.StatusInternalServerError, "Failed to get account status.")
@@ -35,15 +40,131 @@ func getContextIds(c echo.Context) (string, AccountId, error) {
 	return sessionId, accountId, nil
 }
 
-func collectCircleData(info *CircleInfo) map[string]interface{} {
-	defaultSubcirclePermissions := make(map[string]interface{}, len(info.DefaultSubcirclePermissions))
-	for number, granted := range info.DefaultSubcirclePermissions {
-		p := PERMS_ALL[number-1]
-		defaultSubcirclePermissions[p.Name] = map[string]interface{}{
+const (
+	CONTEXT_ACCOUNT_ID string = "account_id"
+	CONTEXT_CIRCLE_ID string = "circle_id"
+	CONTEXT_PERMISSIONS string = "permissions"
+)
+
+//resolves the account and the :circle param once, responding with an error if the account is missing any of the
+//permissions. handlers get the results with getPermissionContext
+func RequirePermission(required ...Permission) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			_, accountId, err := getContextIds(c)
+			if err != nil {
+				return err
+			}
+
+			cirlceIdString := c.Param("circle")
+			circleId, err := strconv.ParseInt(cirlceIdString, 10, 64)
+			if err != nil {
+				return echo.NewHTTPError(http.StatusUnprocessableEntity, "Circle ID must be an integer.")
+			}
+			permissions, err := GetAccountPermissions(accountId, circleId)
+			if err != nil {
+				c.Logger().Error(err)
+				return echo.NewHTTPError(http.StatusInternalServerError, "Failed to check circle permissions.")
+			}
+			c.Set(CONTEXT_ACCOUNT_ID, accountId)
+			c.Set(CONTEXT_CIRCLE_ID, circleId)
+			c.Set(CONTEXT_PERMISSIONS, permissions)
+
+			if err := checkPermissions(permissions, required); err != nil {
+				return err
+			}
+			return next(c)
+		}
+	}
+}
+
+//gets what RequirePermission resolved, the permissions are nil if the account has none in the circle
+func getPermissionContext(c echo.Context) (AccountId, CircleId, PermissionsList) {
+	accountId, _ := c.Get(CONTEXT_ACCOUNT_ID).(AccountId)
+	circleId, _ := c.Get(CONTEXT_CIRCLE_ID).(CircleId)
+	permissions, _ := c.Get(CONTEXT_PERMISSIONS).(PermissionsList)
+	return accountId, circleId, permissions
+}
+
+func checkPermissions(permissions PermissionsList, required []Permission) error {
+	for _, p := range required {
+		if !permissions[p.Number] {
+			return echo.NewHTTPError(http.StatusForbidden, "Missing permission: " + p.Name)
+		}
+	}
+	return nil
+}
+
+//responds with an error if the account can't view the circle
+func ensureCanViewCircle(c echo.Context, accountId AccountId, circleId CircleId) error {
+	return ensurePermissions(c, accountId, circleId, PERM_VIEW_CIRCLE)
+}
+
+//responds with an error if the account is missing any of the permissions in the circle,
+//reusing what RequirePermission resolved when it's for the same account and circle
+func ensurePermissions(c echo.Context, accountId AccountId, circleId CircleId, required ...Permission) error {
+	contextAccountId, contextCircleId, permissions := getPermissionContext(c)
+	if c.Get(CONTEXT_PERMISSIONS) == nil || contextAccountId != accountId || contextCircleId != circleId {
+		var err error
+		permissions, err = GetAccountPermissions(accountId, circleId)
+		if err != nil {
+			c.Logger().Error(err)
+			return echo.NewHTTPError(http.StatusInternalServerError, "Failed to check circle permissions.")
+		}
+	}
+	return checkPermissions(permissions, required)
+}
+
+//stands in for circles that are part of a hierarchy but can't be viewed
+func redactedCircleData(id CircleId) map[string]interface{} {
+	return map[string]interface{}{
+		"id": id,
+		"redacted": true,
+	}
+}
+
+func collectPermissionsData(list PermissionsList) map[string]map[string]interface{} {
+	permissionData := make(map[string]map[string]interface{}, len(list))
+	for n, granted := range list {
+		p := PermissionRegistry.Get(n)
+		permissionData[p.Name] = map[string]interface{}{
 			"display_name": p.DisplayName,
 			"granted": granted,
 		}
 	}
+	return permissionData
+}
+
+//parses a "+" separated list of permission names
+func parsePermissionNames(permissionsString string) ([]PermissionNumber, error) {
+	if len(permissionsString) < 1 {
+		return []PermissionNumber{}, nil
+	}
+	permissionStrings := strings.Split(permissionsString, "+")
+	permissionNumbers := make([]PermissionNumber, len(permissionStrings))
+	for i, pstr := range permissionStrings {
+		pname := strings.TrimSpace(strings.ToLower(pstr))
+		if p, ok := PermissionRegistry.LookupName(pname); ok {
+			permissionNumbers[i] = p.Number
+		} else {
+			return nil, echo.NewHTTPError(http.StatusUnprocessableEntity, "Bad permission name: " + pname)
+		}
+	}
+	return permissionNumbers, nil
+}
+
+//unknown permissions can be cleared but not granted or denied, since nothing would know what they mean
+func rejectUnknownPermissions(permissionNumbers []PermissionNumber) error {
+	for _, n := range permissionNumbers {
+		if _, known := PermissionRegistry.Lookup(n); !known {
+			return echo.NewHTTPError(http.StatusUnprocessableEntity, "Unknown permissions can only be inherited: " + PermissionRegistry.Get(n).Name)
+		}
+	}
+	return nil
+}
+
+func collectCircleData(info *CircleInfo) map[string]interface{} {
+	defaultSubcirclePermissions := collectPermissionsData(info.DefaultSubcirclePermissions)
 	circleData := map[string]interface{}{
 		"id": info.Id,
 		"parent_id": info.ParentId,
@@ -61,22 +182,13 @@ func collectCircleData(info *CircleInfo) map[string]interface{} {
 
 //GET /api/circle/:circle/parent
 func RouteApiCircleParent(c echo.Context) error {
-	_, _, err := getContextIds(c)
-	if err != nil {
-		return err
-	}
-
-	cirlceIdString := c.Param("circle")
-	circleId, err := strconv.ParseInt(cirlceIdString, 10, 64)
-	if err != nil {
-		return echo.NewHTTPError(http.StatusUnprocessableEntity, "Circle ID must be an integer.")
-	}
+	accountId, circleId, _ := getPermissionContext(c)
 
 	info := &CircleInfo{}
 	var rawDefaultSubcirclePermissions []byte
 	row := MainDB.QueryRow(
 		`SELECT id, parent_id, owner_id, name, created, com_type, default_subcircle_com_type, default_subcircle_permissions FROM circles c
-			WHERE EXISTS(SELECT 1 FROM circles c2 WHERE c2.id=? AND c2.parent_id<>NULL AND c.id=c2.parent_id)`,
+			WHERE EXISTS(SELECT 1 FROM circles c2 WHERE c2.id=? AND c2.parent_id IS NOT NULL AND c.id=c2.parent_id)`,
 		circleId,
 	)
 	if err := row.Scan(&info.Id, &info.ParentId, &info.OwnerId, &info.Name, &info.Created, &info.ComType, &info.DefaultSubcircleComType, &rawDefaultSubcirclePermissions); err != nil {
@@ -86,9 +198,19 @@ func RouteApiCircleParent(c echo.Context) error {
 		c.Logger().Error(err)
 		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to find circle parent.")
 	}
-	info.DefaultSubcirclePermissions = PermissionsFromBytes(rawDefaultSubcirclePermissions)
-	
-	circleData := collectCircleData(info)
+	info.DefaultSubcirclePermissions = readDefaultSubcirclePermissions(info.Id, rawDefaultSubcirclePermissions)
+
+	canView, err := CanViewCircle(accountId, info.Id)
+	if err != nil {
+		c.Logger().Error(err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to check circle permissions.")
+	}
+	var circleData map[string]interface{}
+	if canView {
+		circleData = collectCircleData(info)
+	} else {
+		circleData = redactedCircleData(info.Id)
+	}
 	jsonData, err := json.Marshal(circleData)
 	if err != nil {
 		c.Logger().Error(err)
@@ -99,22 +221,13 @@ func RouteApiCircleParent(c echo.Context) error {
 
 //GET /api/circle/:circle/parents
 func RouteApiCircleParents(c echo.Context) error {
-	_, _, err := getContextIds(c)
-	if err != nil {
-		return err
-	}
-
-	cirlceIdString := c.Param("circle")
-	circleId, err := strconv.ParseInt(cirlceIdString, 10, 64)
-	if err != nil {
-		return echo.NewHTTPError(http.StatusUnprocessableEntity, "Circle ID must be an integer.")
-	}
+	accountId, circleId, _ := getPermissionContext(c)
 
 	parents, err := GetAllCircleParents(circleId)
 	if err != nil {
 		c.Logger().Error(err)
 		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get all circle parents.")
-	} else if parents == nil {
+	} else if len(parents) < 1 {
 		return c.JSONBlob(http.StatusOK, []byte("[]"))
 	}
 	parentsOrderMap := make(map[CircleId]int, len(parents))
@@ -142,9 +255,17 @@ func RouteApiCircleParents(c echo.Context) error {
 			c.Logger().Error(err)
 			return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get parent info.")
 		}
-		info.DefaultSubcirclePermissions = PermissionsFromBytes(rawDefaultSubcirclePermissions)
-		circleData := collectCircleData(info)
-		circleDatas[parentsOrderMap[info.Id]] = circleData
+		info.DefaultSubcirclePermissions = readDefaultSubcirclePermissions(info.Id, rawDefaultSubcirclePermissions)
+		canView, err := CanViewCircle(accountId, info.Id)
+		if err != nil {
+			c.Logger().Error(err)
+			return echo.NewHTTPError(http.StatusInternalServerError, "Failed to check circle permissions.")
+		}
+		if canView {
+			circleDatas[parentsOrderMap[info.Id]] = collectCircleData(info)
+		} else {
+			circleDatas[parentsOrderMap[info.Id]] = redactedCircleData(info.Id)
+		}
 	}
 
 	jsonData, err := json.Marshal(circleDatas)
@@ -157,18 +278,9 @@ func RouteApiCircleParents(c echo.Context) error {
 
 //GET /api/circle/:circle/children
 func RouteApiCircleChildren(c echo.Context) error {
-	_, _, err := getContextIds(c)
-	if err != nil {
-		return err
-	}
-
-	cirlceIdString := c.Param("circle")
-	circleId, err := strconv.ParseInt(cirlceIdString, 10, 64)
-	if err != nil {
-		return echo.NewHTTPError(http.StatusUnprocessableEntity, "Circle ID must be an integer.")
-	}
+	accountId, circleId, _ := getPermissionContext(c)
 
-	rows, err := MainDB.Query("SELECT id, owner_id, name, created, com_type, default_subcircle_com_type, default_subcircle_permissions FROM circles WHERE parent_id=?", circleId)
+	rows, err := MainDB.Query("SELECT id, owner_id, name, created, com_type, default_subcircle_com_type, default_subcircle_permissions FROM circles WHERE parent_id=? AND deleted_id IS NULL", circleId)
 	if err == sql.ErrNoRows {
 		return c.JSONBlob(http.StatusOK, []byte("[]"))
 	} else if err != nil {
@@ -185,7 +297,14 @@ func RouteApiCircleChildren(c echo.Context) error {
 			c.Logger().Error(err)
 			return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get child circle info.")
 		}
-		info.DefaultSubcirclePermissions = PermissionsFromBytes(rawDefaultSubcirclePermissions)
+		info.DefaultSubcirclePermissions = readDefaultSubcirclePermissions(info.Id, rawDefaultSubcirclePermissions)
+		canView, err := CanViewCircle(accountId, info.Id)
+		if err != nil {
+			c.Logger().Error(err)
+			return echo.NewHTTPError(http.StatusInternalServerError, "Failed to check circle permissions.")
+		} else if !canView {
+			continue
+		}
 		circleData := collectCircleData(info)
 		circleDatas = append(circleDatas, circleData)
 	}
@@ -200,74 +319,76 @@ func RouteApiCircleChildren(c echo.Context) error {
 
 //GET /api/circle/:circle/hierarchy
 func RouteApiCircleHierarchy(c echo.Context) error {
-	_, _, err := getContextIds(c)
-	if err != nil {
-		return err
-	}
-
-	cirlceIdString := c.Param("circle")
-	circleId, err := strconv.ParseInt(cirlceIdString, 10, 64)
-	if err != nil {
-		return echo.NewHTTPError(http.StatusUnprocessableEntity, "Circle ID must be an integer.")
-	}
+	accountId, circleId, _ := getPermissionContext(c)
 
 	parents, err := GetAllCircleParents(circleId)
 	if err != nil {
 		c.Logger().Error(err)
 		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get all circle parents.")
-	} else if parents == nil {
-		return c.JSONBlob(http.StatusOK, []byte("[]"))
 	}
 	parentsOrderMap := make(map[CircleId]int, len(parents))
 	for i, parentId := range parents {
 		parentsOrderMap[parentId] = i
 	}
 
-	parentIdSet := idSetString(parents)
-	queryString := "SELECT id, parent_id, owner_id, name, created, com_type, default_subcircle_com_type, default_subcircle_permissions FROM circles WHERE id IN " + parentIdSet
-	rowsParents, err := MainDB.Query(queryString)
-	if err == sql.ErrNoRows {
-		c.Logger().Warnf("Parent IDs for circle %d could not be found.", circleId)
-		return c.JSONBlob(http.StatusOK, []byte("[]"))
-	} else if err != nil {
-		c.Logger().Error(err)
-		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get circle parent info.")
-	}
-	defer rowsParents.Close()
-
-	rowsChildren, err := MainDB.Query("SELECT id, owner_id, name, created, com_type, default_subcircle_com_type, default_subcircle_permissions FROM circles WHERE parent_id=?", circleId)
-	if err == sql.ErrNoRows {
-		return c.JSONBlob(http.StatusOK, []byte("[]"))
-	} else if err != nil {
-		c.Logger().Error(err)
-		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get child circle info.")
-	}
-	defer rowsChildren.Close()
-
 	parentsDatas := make([]map[string]interface{}, len(parents))
-	for rowsParents.Next() {
-		info := &CircleInfo{}
-		var rawDefaultSubcirclePermissions []byte
-		if err := rowsParents.Scan(&info.Id, &info.ParentId, &info.OwnerId, &info.Name, &info.Created, &info.ComType, &info.DefaultSubcircleComType, &rawDefaultSubcirclePermissions); err != nil {
+	if len(parents) > 0 {
+		parentIdSet := idSetString(parents)
+		queryString := "SELECT id, parent_id, owner_id, name, created, com_type, default_subcircle_com_type, default_subcircle_permissions FROM circles WHERE id IN " + parentIdSet
+		rowsParents, err := MainDB.Query(queryString)
+		if err != nil && err != sql.ErrNoRows {
 			c.Logger().Error(err)
-			return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get parent info.")
+			return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get circle parent info.")
+		} else if err == nil {
+			defer rowsParents.Close()
+			for rowsParents.Next() {
+				info := &CircleInfo{}
+				var rawDefaultSubcirclePermissions []byte
+				if err := rowsParents.Scan(&info.Id, &info.ParentId, &info.OwnerId, &info.Name, &info.Created, &info.ComType, &info.DefaultSubcircleComType, &rawDefaultSubcirclePermissions); err != nil {
+					c.Logger().Error(err)
+					return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get parent info.")
+				}
+				info.DefaultSubcirclePermissions = readDefaultSubcirclePermissions(info.Id, rawDefaultSubcirclePermissions)
+				canView, err := CanViewCircle(accountId, info.Id)
+				if err != nil {
+					c.Logger().Error(err)
+					return echo.NewHTTPError(http.StatusInternalServerError, "Failed to check circle permissions.")
+				}
+				if canView {
+					parentsDatas[parentsOrderMap[info.Id]] = collectCircleData(info)
+				} else {
+					parentsDatas[parentsOrderMap[info.Id]] = redactedCircleData(info.Id)
+				}
+			}
+		} else {
+			c.Logger().Warnf("Parent IDs for circle %d could not be found.", circleId)
 		}
-		info.DefaultSubcirclePermissions = PermissionsFromBytes(rawDefaultSubcirclePermissions)
-		circleData := collectCircleData(info)
-		parentsDatas[parentsOrderMap[info.Id]] = circleData
 	}
 
 	childrenDatas := make([]map[string]interface{}, 0)
-	for rowsChildren.Next() {
-		info := &CircleInfo{ParentId: &circleId}
-		var rawDefaultSubcirclePermissions []byte
-		if err := rowsChildren.Scan(&info.Id, &info.OwnerId, &info.Name, &info.Created, &info.ComType, &info.DefaultSubcircleComType, &rawDefaultSubcirclePermissions); err != nil {
-			c.Logger().Error(err)
-			return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get child circle info.")
+	rowsChildren, err := MainDB.Query("SELECT id, owner_id, name, created, com_type, default_subcircle_com_type, default_subcircle_permissions FROM circles WHERE parent_id=? AND deleted_id IS NULL", circleId)
+	if err != nil && err != sql.ErrNoRows {
+		c.Logger().Error(err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get child circle info.")
+	} else if err == nil {
+		defer rowsChildren.Close()
+		for rowsChildren.Next() {
+			info := &CircleInfo{ParentId: &circleId}
+			var rawDefaultSubcirclePermissions []byte
+			if err := rowsChildren.Scan(&info.Id, &info.OwnerId, &info.Name, &info.Created, &info.ComType, &info.DefaultSubcircleComType, &rawDefaultSubcirclePermissions); err != nil {
+				c.Logger().Error(err)
+				return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get child circle info.")
+			}
+			info.DefaultSubcirclePermissions = readDefaultSubcirclePermissions(info.Id, rawDefaultSubcirclePermissions)
+			canView, err := CanViewCircle(accountId, info.Id)
+			if err != nil {
+				c.Logger().Error(err)
+				return echo.NewHTTPError(http.StatusInternalServerError, "Failed to check circle permissions.")
+			} else if !canView {
+				continue
+			}
+			childrenDatas = append(childrenDatas, collectCircleData(info))
 		}
-		info.DefaultSubcirclePermissions = PermissionsFromBytes(rawDefaultSubcirclePermissions)
-		circleData := collectCircleData(info)
-		childrenDatas = append(childrenDatas, circleData)
 	}
 
 	hierarchyData := map[string][]map[string]interface{}{
@@ -283,18 +404,21 @@ func RouteApiCircleHierarchy(c echo.Context) error {
 	return c.JSONBlob(http.StatusOK, jsonData)
 }
 
+func collectRoleData(role *RoleInfo) map[string]interface{} {
+	colorStr := fmt.Sprintf("%02x%02x%02x", role.Color[0], role.Color[1], role.Color[2])
+	return map[string]interface{}{
+		"id": role.Id,
+		"circle_id": role.CircleId,
+		"order": role.Order,
+		"name": role.Name,
+		"color": colorStr,
+	}
+}
+
 //GET /api/circle/:circle/roles
 func RouteApiCircleRoles(c echo.Context) error {
-	_, accountId, err := getContextIds(c)
-	if err != nil {
-		return err
-	}
-	
-	cirlceIdString := c.Param("circle")
-	circleId, err := strconv.ParseInt(cirlceIdString, 10, 64)
-	if err != nil {
-		return echo.NewHTTPError(http.StatusUnprocessableEntity, "Circle ID must be an integer.")
-	}
+	accountId, circleId, _ := getPermissionContext(c)
+
 	roles, err := GetAccountRolesInfo(accountId, circleId)
 	if roles == nil {
 		if err != nil {
@@ -306,13 +430,10 @@ func RouteApiCircleRoles(c echo.Context) error {
 	}
 
 	roleData := make(map[string]map[string]interface{}, len(roles))
-	for _, role := range roles {
-		colorStr := fmt.Sprintf("%02x%02x%02x", role.Color[0], role.Color[1], role.Color[2])
-		roleData[role.Name] = map[string]interface{}{
-			"circle_id": role.CircleId,
-			"order": role.Order,
-			"name": role.Name,
-			"color": colorStr,
+	//roles are nearest circle first, so inherited roles don't replace the circle's own ones with the same name
+	for i := range roles {
+		if _, ok := roleData[roles[i].Name]; !ok {
+			roleData[roles[i].Name] = collectRoleData(&roles[i])
 		}
 	}
 
@@ -326,17 +447,9 @@ func RouteApiCircleRoles(c echo.Context) error {
 
 //GET /api/circle/:circle/roles/permissions?names
 func RouteApiCircleRolesPermissions(c echo.Context) error {
-	_, accountId, err := getContextIds(c)
-	if err != nil {
-		return err
-	}
-	
-	cirlceIdString := c.Param("circle")
-	circleId, err := strconv.ParseInt(cirlceIdString, 10, 64)
-	if err != nil {
-		return echo.NewHTTPError(http.StatusUnprocessableEntity, "Circle ID must be an integer.")
-	}
-	roles, err := GetAccountRoles(accountId, circleId)
+	accountId, circleId, _ := getPermissionContext(c)
+
+	roles, err := GetAccountPermissionRoles(accountId, circleId)
 	if roles == nil {
 		if err != nil {
 			c.Logger().Error(err)
@@ -348,19 +461,13 @@ func RouteApiCircleRolesPermissions(c echo.Context) error {
 	permissionsString := c.QueryParam("names")
 	var permissionList PermissionsList
 	if len(permissionsString) > 0 {
-		permissionStrings := strings.Split(permissionsString, "+")
-		permissionNumbers := make([]PermissionNumber, len(permissionStrings))
-		for i, pstr := range permissionStrings {
-			pname := strings.TrimSpace(strings.ToLower(pstr))
-			if p, ok := PERMS_NAME_MAP[pname]; ok {
-				permissionNumbers[i] = p.Number
-			} else {
-				return echo.NewHTTPError(http.StatusUnprocessableEntity, "Bad permission name: " + pname)
-			}
+		permissionNumbers, err := parsePermissionNames(permissionsString)
+		if err != nil {
+			return err
 		}
 		permissionList, err = GetSomePermissions(circleId, roles, permissionNumbers)
 	} else {
-		permissionList, err = GetAllPermissions(circleId, )
+		permissionList, err = GetAllPermissions(circleId, roles...)
 	}
 
 	if err != nil {
@@ -368,19 +475,3008 @@ func RouteApiCircleRolesPermissions(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get list of permissions.")
 	}
 
-	permissionData := make(map[string]map[string]interface{}, len(permissionList))
-	for n, granted := range permissionList {
-		p := PERMS_ALL[n-1]
-		permissionData[p.Name] = map[string]interface{}{
-			"display_name": p.DisplayName,
-			"granted": granted,
+	permissionData := collectPermissionsData(permissionList)
+
+	jsonData, err := json.Marshal(permissionData)
+	if err != nil {
+		c.Logger().Error(err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to format permission data.")
+	}
+	return c.JSONBlob(http.StatusOK, jsonData)
+}
+
+
+func parseComType(value string) (CommunicationType, error) {
+	n, err := strconv.ParseInt(value, 10, 8)
+	if err != nil {
+		return 0, err
+	}
+	comType := CommunicationType(n)
+	if comType != COM_TYPE_POST && comType != COM_TYPE_MESSAGE {
+		return 0, fmt.Errorf("unknown communication type %d", comType)
+	}
+	return comType, nil
+}
+
+//shared by the circle and subcircle creation routes, parent is nil for root circles
+func createCircleFromForm(c echo.Context, accountId AccountId, parent *CircleInfo) error {
+	name := strings.TrimSpace(c.FormValue("name"))
+	if l := len(name); l < 1 || l > 64 {
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, "Circle name must be between 1 and 64 characters.")
+	}
+
+	info := CircleInfo{OwnerId: accountId, Name: name}
+	var permissions map[string]PermissionsList = nil
+	if parent != nil {
+		info.ParentId = &parent.Id
+	} else {
+		//root circles have nothing to inherit from, so let people find them
+		permissions = map[string]PermissionsList{
+			ROLE_NAME_EVERYONE: {PERM_VIEW_CIRCLE.Number: true},
 		}
 	}
 
-	jsonData, err := json.Marshal(permissionData)
+	if comTypeString := c.FormValue("com_type"); len(comTypeString) > 0 {
+		comType, err := parseComType(comTypeString)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusUnprocessableEntity, "Invalid communication type.")
+		}
+		info.ComType = comType
+	} else if parent != nil && parent.DefaultSubcircleComType != nil {
+		info.ComType = *parent.DefaultSubcircleComType
+	} else {
+		return echo.NewHTTPError(http.StatusBadRequest, "Missing form value: \"com_type\"")
+	}
+
+	if defaultComTypeString := c.FormValue("default_subcircle_com_type"); len(defaultComTypeString) > 0 {
+		defaultComType, err := parseComType(defaultComTypeString)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusUnprocessableEntity, "Invalid default subcircle communication type.")
+		}
+		info.DefaultSubcircleComType = &defaultComType
+	}
+
+	circleId, err := CreateCircle(info, nil, permissions)
+	if err != nil {
+		if _, ok := err.(*DuplicateCircleNameError); ok {
+			return echo.NewHTTPError(http.StatusConflict, "A circle with this name already exists here.")
+		}
+		c.Logger().Error(err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to create circle.")
+	}
+
+	created, err := GetCircleInfo(circleId)
+	if err != nil || created == nil {
+		if err != nil {
+			c.Logger().Error(err)
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get created circle info.")
+	}
+	jsonData, err := json.Marshal(collectCircleData(created))
+	if err != nil {
+		c.Logger().Error(err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to format circle data.")
+	}
+	return c.JSONBlob(http.StatusCreated, jsonData)
+}
+
+//POST /api/circle
+func RouteApiCircleCreate(c echo.Context) error {
+	_, accountId, err := getContextIds(c)
+	if err != nil {
+		return err
+	}
+	return createCircleFromForm(c, accountId, nil)
+}
+
+//POST /api/circle/:circle/children
+func RouteApiCircleCreateChild(c echo.Context) error {
+	accountId, circleId, _ := getPermissionContext(c)
+
+	parent, err := GetCircleInfo(circleId)
+	if err != nil {
+		c.Logger().Error(err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get circle info.")
+	} else if parent == nil {
+		return echo.NewHTTPError(http.StatusNotFound, "Circle not found.")
+	}
+	return createCircleFromForm(c, accountId, parent)
+}
+
+//POST /api/circle/:circle/default_subcircle/com_type
+func RouteApiCircleDefaultComTypeEdit(c echo.Context) error {
+	_, circleId, _ := getPermissionContext(c)
+
+	//an empty value clears the default
+	var comType *CommunicationType = nil
+	if comTypeString := c.FormValue("com_type"); len(comTypeString) > 0 {
+		comTypeValue, err := parseComType(comTypeString)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusUnprocessableEntity, "Invalid communication type.")
+		}
+		comType = &comTypeValue
+	}
+
+	if err := SetDefaultSubcircleComType(circleId, comType); err != nil {
+		c.Logger().Error(err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to change default subcircle communication type.")
+	}
+
+	jsonData, err := json.Marshal(map[string]interface{}{"com_type": comType})
+	if err != nil {
+		c.Logger().Error(err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to format default subcircle data.")
+	}
+	return c.JSONBlob(http.StatusOK, jsonData)
+}
+
+//parses the optional role form/query value used for per-role subcircle defaults, 0 means ::everyone
+func getDefaultSubcircleRole(c echo.Context, roleString string, circleId CircleId) (RoleId, error) {
+	if len(roleString) < 1 {
+		return 0, nil
+	}
+	roleId, err := strconv.ParseInt(roleString, 10, 64)
+	if err != nil {
+		return 0, echo.NewHTTPError(http.StatusUnprocessableEntity, "Role ID must be an integer.")
+	}
+	ok, err := IsRoleInCircleTree(roleId, circleId)
+	if err != nil {
+		c.Logger().Error(err)
+		return 0, echo.NewHTTPError(http.StatusInternalServerError, "Failed to check role.")
+	} else if !ok {
+		return 0, echo.NewHTTPError(http.StatusNotFound, "Role not found in this circle.")
+	}
+	return roleId, nil
+}
+
+//format value for permissions as a plain object of permission names to granted, see PermissionsToJSON
+const PERMISSIONS_FORMAT_JSON string = "json"
+
+//checks the optional format value, either the default per-permission data or PERMISSIONS_FORMAT_JSON
+func parsePermissionsFormat(format string) (bool, error) {
+	switch format {
+	case "":
+		return false, nil
+	case PERMISSIONS_FORMAT_JSON:
+		return true, nil
+	}
+	return false, echo.NewHTTPError(http.StatusUnprocessableEntity, "Unknown format: " + format)
+}
+
+//GET /api/circle/:circle/default_subcircle/permissions?role&format
+func RouteApiCircleDefaultPermissions(c echo.Context) error {
+	_, circleId, _ := getPermissionContext(c)
+
+	asJSON, err := parsePermissionsFormat(c.QueryParam("format"))
+	if err != nil {
+		return err
+	}
+	roleId, err := getDefaultSubcircleRole(c, c.QueryParam("role"), circleId)
+	if err != nil {
+		return err
+	}
+
+	var permissionList PermissionsList
+	if roleId == 0 {
+		info, err := GetCircleInfo(circleId)
+		if err != nil {
+			c.Logger().Error(err)
+			return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get circle info.")
+		} else if info == nil {
+			return echo.NewHTTPError(http.StatusNotFound, "Circle not found.")
+		}
+		permissionList = info.DefaultSubcirclePermissions
+	} else {
+		defaults, err := GetDefaultSubcircleRolePermissions(circleId)
+		if err != nil {
+			c.Logger().Error(err)
+			return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get default subcircle permissions.")
+		}
+		permissionList = defaults[roleId]
+	}
+
+	var jsonData []byte
+	if asJSON {
+		jsonData, err = PermissionsToJSON(permissionList)
+	} else {
+		jsonData, err = json.Marshal(collectPermissionsData(permissionList))
+	}
 	if err != nil {
 		c.Logger().Error(err)
 		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to format permission data.")
 	}
 	return c.JSONBlob(http.StatusOK, jsonData)
 }
+
+//reads the new defaults either from the grant and deny lists or, with format=json, from a permissions object
+func parseDefaultPermissionsForm(c echo.Context) (PermissionsList, error) {
+	asJSON, err := parsePermissionsFormat(c.FormValue("format"))
+	if err != nil {
+		return nil, err
+	}
+	if asJSON {
+		permissionList, err := PermissionsFromJSON([]byte(c.FormValue("permissions")))
+		if err != nil {
+			return nil, echo.NewHTTPError(http.StatusUnprocessableEntity, "Bad permissions JSON.")
+		}
+		numbers := make([]PermissionNumber, 0, len(permissionList))
+		for n := range permissionList {
+			numbers = append(numbers, n)
+		}
+		if err := rejectUnknownPermissions(numbers); err != nil {
+			return nil, err
+		}
+		return permissionList, nil
+	}
+
+	granted, err := parsePermissionNames(c.FormValue("grant"))
+	if err != nil {
+		return nil, err
+	} else if err := rejectUnknownPermissions(granted); err != nil {
+		return nil, err
+	}
+	denied, err := parsePermissionNames(c.FormValue("deny"))
+	if err != nil {
+		return nil, err
+	} else if err := rejectUnknownPermissions(denied); err != nil {
+		return nil, err
+	}
+	permissionList := make(PermissionsList, len(granted)+len(denied))
+	for _, n := range granted {
+		permissionList[n] = true
+	}
+	for _, n := range denied {
+		if _, ok := permissionList[n]; ok {
+			return nil, echo.NewHTTPError(http.StatusUnprocessableEntity, "Permission cannot be granted and denied: " + PermissionRegistry.Get(n).Name)
+		}
+		permissionList[n] = false
+	}
+	return permissionList, nil
+}
+
+//POST /api/circle/:circle/default_subcircle/permissions
+func RouteApiCircleDefaultPermissionsEdit(c echo.Context) error {
+	_, circleId, _ := getPermissionContext(c)
+
+	roleId, err := getDefaultSubcircleRole(c, c.FormValue("role"), circleId)
+	if err != nil {
+		return err
+	}
+	permissionList, err := parseDefaultPermissionsForm(c)
+	if err != nil {
+		return err
+	}
+
+	if roleId == 0 {
+		err = SetDefaultSubcirclePermissions(circleId, permissionList)
+	} else {
+		err = SetDefaultSubcircleRolePermissions(circleId, roleId, permissionList)
+	}
+	if err != nil {
+		c.Logger().Error(err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to change default subcircle permissions.")
+	}
+
+	jsonData, err := json.Marshal(collectPermissionsData(permissionList))
+	if err != nil {
+		c.Logger().Error(err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to format permission data.")
+	}
+	return c.JSONBlob(http.StatusOK, jsonData)
+}
+
+//root circles can only be deleted by their owner, subcircles need PERM_DELETE_SUBCIRCLE in the parent
+func ensureCanDeleteCircle(c echo.Context, accountId AccountId, info *CircleInfo) error {
+	if info.ParentId == nil {
+		if info.OwnerId != accountId {
+			return echo.NewHTTPError(http.StatusForbidden, "Only the owner can delete this circle.")
+		}
+		return nil
+	}
+	return ensurePermissions(c, accountId, *info.ParentId, PERM_DELETE_SUBCIRCLE)
+}
+
+func collectCircleDeletionData(deletion *CircleDeletion) map[string]interface{} {
+	return map[string]interface{}{
+		"circle_id": deletion.CircleId,
+		"account_id": deletion.AccountId,
+		"deleted": deletion.Deleted.Format(time.RFC3339),
+		"purge_after": deletion.PurgeAfter.Format(time.RFC3339),
+	}
+}
+
+//DELETE /api/circle/:circle
+func RouteApiCircleDelete(c echo.Context) error {
+	accountId, circleId, _ := getPermissionContext(c)
+
+	info, err := GetCircleInfo(circleId)
+	if err != nil {
+		c.Logger().Error(err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get circle info.")
+	} else if info == nil {
+		return echo.NewHTTPError(http.StatusNotFound, "Circle not found.")
+	}
+	if err := ensureCanDeleteCircle(c, accountId, info); err != nil {
+		return err
+	}
+
+	deletion, err := DeleteCircle(circleId, accountId)
+	if err != nil {
+		c.Logger().Error(err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to delete circle.")
+	}
+
+	jsonData, err := json.Marshal(collectCircleDeletionData(&deletion))
+	if err != nil {
+		c.Logger().Error(err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to format circle deletion data.")
+	}
+	return c.JSONBlob(http.StatusOK, jsonData)
+}
+
+//POST /api/circle/:circle/restore
+func RouteApiCircleRestore(c echo.Context) error {
+	accountId, circleId, _ := getPermissionContext(c)
+
+	info, err := GetCircleInfoIncludeDeleted(circleId)
+	if err != nil {
+		c.Logger().Error(err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get circle info.")
+	} else if info == nil {
+		return echo.NewHTTPError(http.StatusNotFound, "Circle not found.")
+	}
+	if err := ensureCanDeleteCircle(c, accountId, info); err != nil {
+		return err
+	}
+
+	if err := RestoreCircle(circleId); err != nil {
+		switch err.(type) {
+		case *CircleRestoreError:
+			return echo.NewHTTPError(http.StatusConflict, "This circle cannot be restored.")
+		case *DuplicateCircleNameError:
+			return echo.NewHTTPError(http.StatusConflict, "A circle with this name already exists here.")
+		}
+		c.Logger().Error(err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to restore circle.")
+	}
+
+	jsonData, err := json.Marshal(collectCircleData(info))
+	if err != nil {
+		c.Logger().Error(err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to format circle data.")
+	}
+	return c.JSONBlob(http.StatusOK, jsonData)
+}
+
+//GET /api/circle/:circle/trash
+func RouteApiCircleTrash(c echo.Context) error {
+	_, circleId, _ := getPermissionContext(c)
+
+	deletions, err := GetCircleChildDeletions(circleId)
+	if err != nil {
+		c.Logger().Error(err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get deleted subcircles.")
+	}
+	deletionDatas := make([]map[string]interface{}, len(deletions))
+	for i := range deletions {
+		deletionDatas[i] = collectCircleDeletionData(&deletions[i])
+	}
+
+	jsonData, err := json.Marshal(deletionDatas)
+	if err != nil {
+		c.Logger().Error(err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to format deleted subcircle data.")
+	}
+	return c.JSONBlob(http.StatusOK, jsonData)
+}
+
+//POST /api/circle/:circle/name
+func RouteApiCircleRename(c echo.Context) error {
+	_, circleId, _ := getPermissionContext(c)
+
+	name := strings.TrimSpace(c.FormValue("name"))
+	if l := len(name); l < 1 || l > 64 {
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, "Circle name must be between 1 and 64 characters.")
+	}
+
+	if err := RenameCircle(circleId, name); err != nil {
+		if _, ok := err.(*DuplicateCircleNameError); ok {
+			return echo.NewHTTPError(http.StatusConflict, "A circle with this name already exists here.")
+		}
+		c.Logger().Error(err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to rename circle.")
+	}
+
+	info, err := GetCircleInfo(circleId)
+	if err != nil || info == nil {
+		if err != nil {
+			c.Logger().Error(err)
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get renamed circle info.")
+	}
+	jsonData, err := json.Marshal(collectCircleData(info))
+	if err != nil {
+		c.Logger().Error(err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to format circle data.")
+	}
+	return c.JSONBlob(http.StatusOK, jsonData)
+}
+
+func collectCircleInheritanceData(circleId CircleId, inheritance *CircleInheritance) map[string]interface{} {
+	return map[string]interface{}{
+		"circle_id": circleId,
+		"inherit_roles": inheritance.InheritRoles,
+		"follow_parent_membership": inheritance.FollowParentMembership,
+	}
+}
+
+func circleInheritanceResponse(c echo.Context, circleId CircleId) error {
+	inheritance, err := GetCircleInheritance(circleId)
+	if err != nil {
+		c.Logger().Error(err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get circle inheritance.")
+	} else if inheritance == nil {
+		return echo.NewHTTPError(http.StatusNotFound, "Circle not found.")
+	}
+
+	jsonData, err := json.Marshal(collectCircleInheritanceData(circleId, inheritance))
+	if err != nil {
+		c.Logger().Error(err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to format circle inheritance data.")
+	}
+	return c.JSONBlob(http.StatusOK, jsonData)
+}
+
+//GET /api/circle/:circle/inheritance
+func RouteApiCircleInheritance(c echo.Context) error {
+	_, circleId, _ := getPermissionContext(c)
+	return circleInheritanceResponse(c, circleId)
+}
+
+//parses an optional true or false form value
+func parseOptionalBool(c echo.Context, name string) (*bool, error) {
+	valueString := c.FormValue(name)
+	if len(valueString) < 1 {
+		return nil, nil
+	}
+	value, err := strconv.ParseBool(valueString)
+	if err != nil {
+		return nil, echo.NewHTTPError(http.StatusUnprocessableEntity, "Form value must be true or false: " + name)
+	}
+	return &value, nil
+}
+
+//POST /api/circle/:circle/inheritance
+func RouteApiCircleInheritanceEdit(c echo.Context) error {
+	accountId, circleId, _ := getPermissionContext(c)
+
+	inheritRoles, err := parseOptionalBool(c, "inherit_roles")
+	if err != nil {
+		return err
+	}
+	followParentMembership, err := parseOptionalBool(c, "follow_parent_membership")
+	if err != nil {
+		return err
+	}
+	if inheritRoles == nil && followParentMembership == nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Missing form values: \"inherit_roles\", \"follow_parent_membership\"")
+	}
+	//following the parent brings in members, which is the same as inviting them
+	if followParentMembership != nil {
+		if err := ensurePermissions(c, accountId, circleId, PERM_INVITE_CIRCLE_MEMBERS); err != nil {
+			return err
+		}
+	}
+
+	if err := SetCircleInheritance(circleId, inheritRoles, followParentMembership, accountId); err != nil {
+		if err == sql.ErrNoRows {
+			return echo.NewHTTPError(http.StatusNotFound, "Circle not found.")
+		}
+		c.Logger().Error(err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to change circle inheritance.")
+	}
+	return circleInheritanceResponse(c, circleId)
+}
+
+//POST /api/circle/:circle/parent
+func RouteApiCircleMove(c echo.Context) error {
+	accountId, circleId, _ := getPermissionContext(c)
+
+	info, err := GetCircleInfo(circleId)
+	if err != nil {
+		c.Logger().Error(err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get circle info.")
+	} else if info == nil {
+		return echo.NewHTTPError(http.StatusNotFound, "Circle not found.")
+	}
+
+	//an empty parent makes the circle a root circle
+	var newParentId *CircleId = nil
+	if parentIdString := c.FormValue("parent_id"); len(parentIdString) > 0 {
+		parentId, err := strconv.ParseInt(parentIdString, 10, 64)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusUnprocessableEntity, "Parent ID must be an integer.")
+		}
+		newParentId = &parentId
+	}
+	if (newParentId == nil && info.ParentId == nil) || (newParentId != nil && info.ParentId != nil && *newParentId == *info.ParentId) {
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, "Circle is already in this parent.")
+	}
+
+	//leaving the old parent is treated like deleting a subcircle from it, joining the new one like creating one
+	if info.ParentId != nil {
+		if err := ensurePermissions(c, accountId, *info.ParentId, PERM_DELETE_SUBCIRCLE); err != nil {
+			return err
+		}
+	} else if info.OwnerId != accountId {
+		return echo.NewHTTPError(http.StatusForbidden, "Only the owner can move this circle.")
+	}
+	if newParentId != nil {
+		if err := ensurePermissions(c, accountId, *newParentId, PERM_CREATE_SUBCIRCLE); err != nil {
+			return err
+		}
+	} else if info.OwnerId != accountId {
+		return echo.NewHTTPError(http.StatusForbidden, "Only the owner can make this a root circle.")
+	}
+
+	if err := MoveCircle(circleId, newParentId); err != nil {
+		switch err.(type) {
+		case *CircleCycleError:
+			return echo.NewHTTPError(http.StatusConflict, "A circle cannot be moved into itself or its subcircles.")
+		case *DuplicateCircleNameError:
+			return echo.NewHTTPError(http.StatusConflict, "A circle with this name already exists in the new parent.")
+		}
+		c.Logger().Error(err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to move circle.")
+	}
+
+	info.ParentId = newParentId
+	jsonData, err := json.Marshal(collectCircleData(info))
+	if err != nil {
+		c.Logger().Error(err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to format circle data.")
+	}
+	return c.JSONBlob(http.StatusOK, jsonData)
+}
+
+//parses a "+" separated list of IDs
+func parseIdList(idsString string) ([]int64, error) {
+	if len(idsString) < 1 {
+		return []int64{}, nil
+	}
+	idStrings := strings.Split(idsString, "+")
+	ids := make([]int64, len(idStrings))
+	for i, idString := range idStrings {
+		id, err := strconv.ParseInt(strings.TrimSpace(idString), 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		ids[i] = id
+	}
+	return ids, nil
+}
+
+func collectMemberData(member *MemberInfo) map[string]interface{} {
+	return map[string]interface{}{
+		"id": member.Id,
+		"account_id": member.AccountId,
+		"circle_id": member.CircleId,
+		"username": member.Username,
+		"joined": member.Joined.Format(time.RFC3339),
+	}
+}
+
+func collectInviteData(invite *InviteInfo) map[string]interface{} {
+	var expires *string = nil
+	if invite.Expires != nil {
+		expiresString := invite.Expires.Format(time.RFC3339)
+		expires = &expiresString
+	}
+	roles := invite.Roles
+	if roles == nil {
+		roles = []RoleId{}
+	}
+	return map[string]interface{}{
+		"code": invite.Code,
+		"circle_id": invite.CircleId,
+		"creator_id": invite.CreatorId,
+		"created": invite.Created.Format(time.RFC3339),
+		"expires": expires,
+		"max_uses": invite.MaxUses,
+		"uses": invite.Uses,
+		"revoked": invite.Revoked,
+		"usable": invite.Usable(time.Now()),
+		"roles": roles,
+	}
+}
+
+//GET /api/circle/:circle/members
+func RouteApiCircleMembers(c echo.Context) error {
+	_, circleId, _ := getPermissionContext(c)
+
+	members, err := GetCircleMembers(circleId)
+	if err != nil {
+		c.Logger().Error(err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get circle members.")
+	}
+	memberDatas := make([]map[string]interface{}, len(members))
+	for i := range members {
+		memberDatas[i] = collectMemberData(&members[i])
+	}
+
+	jsonData, err := json.Marshal(memberDatas)
+	if err != nil {
+		c.Logger().Error(err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to format member data.")
+	}
+	return c.JSONBlob(http.StatusOK, jsonData)
+}
+
+//POST /api/circle/:circle/join
+func RouteApiCircleJoin(c echo.Context) error {
+	accountId, circleId, _ := getPermissionContext(c)
+
+	memberId, err := JoinCircle(accountId, circleId, nil)
+	if err != nil {
+		switch err.(type) {
+		case *AlreadyMemberError:
+			return echo.NewHTTPError(http.StatusConflict, "Already a member of this circle.")
+		case *BannedError:
+			return echo.NewHTTPError(http.StatusForbidden, "Banned from this circle.")
+		}
+		c.Logger().Error(err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to join circle.")
+	}
+	EventHub.Publish(circleId, EVENT_MEMBER_JOIN, map[string]interface{}{"id": memberId, "account_id": accountId})
+
+	jsonData, err := json.Marshal(map[string]interface{}{"id": memberId, "circle_id": circleId})
+	if err != nil {
+		c.Logger().Error(err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to format member data.")
+	}
+	return c.JSONBlob(http.StatusOK, jsonData)
+}
+
+//POST /api/circle/:circle/leave
+func RouteApiCircleLeave(c echo.Context) error {
+	accountId, circleId, _ := getPermissionContext(c)
+
+	info, err := GetCircleInfo(circleId)
+	if err != nil {
+		c.Logger().Error(err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get circle info.")
+	} else if info == nil {
+		return echo.NewHTTPError(http.StatusNotFound, "Circle not found.")
+	} else if info.OwnerId == accountId {
+		return echo.NewHTTPError(http.StatusForbidden, "The owner cannot leave their circle.")
+	}
+
+	if err := LeaveCircle(accountId, circleId, accountId); err != nil {
+		if err == sql.ErrNoRows {
+			return echo.NewHTTPError(http.StatusNotFound, "Not a member of this circle.")
+		}
+		c.Logger().Error(err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to leave circle.")
+	}
+	EventHub.Publish(circleId, EVENT_MEMBER_LEAVE, map[string]interface{}{"account_id": accountId, "reason": "leave"})
+	return c.NoContent(http.StatusOK)
+}
+
+//GET /api/circle/:circle/invites
+func RouteApiCircleInvites(c echo.Context) error {
+	_, circleId, _ := getPermissionContext(c)
+
+	invites, err := GetCircleInvites(circleId)
+	if err != nil {
+		c.Logger().Error(err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get circle invites.")
+	}
+	inviteDatas := make([]map[string]interface{}, len(invites))
+	for i := range invites {
+		inviteDatas[i] = collectInviteData(&invites[i])
+	}
+
+	jsonData, err := json.Marshal(inviteDatas)
+	if err != nil {
+		c.Logger().Error(err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to format invite data.")
+	}
+	return c.JSONBlob(http.StatusOK, jsonData)
+}
+
+//POST /api/circle/:circle/invites
+func RouteApiCircleInviteCreate(c echo.Context) error {
+	accountId, circleId, _ := getPermissionContext(c)
+
+	var expires *time.Time = nil
+	if expiresInString := c.FormValue("expires_in"); len(expiresInString) > 0 {
+		expiresIn, err := strconv.ParseInt(expiresInString, 10, 64)
+		if err != nil || expiresIn < 1 {
+			return echo.NewHTTPError(http.StatusUnprocessableEntity, "Expiry must be a positive number of seconds.")
+		}
+		expiresValue := time.Now().Add(time.Duration(expiresIn) * time.Second)
+		expires = &expiresValue
+	}
+	var maxUses *int = nil
+	if maxUsesString := c.FormValue("max_uses"); len(maxUsesString) > 0 {
+		maxUsesValue, err := strconv.Atoi(maxUsesString)
+		if err != nil || maxUsesValue < 1 {
+			return echo.NewHTTPError(http.StatusUnprocessableEntity, "Max uses must be a positive integer.")
+		}
+		maxUses = &maxUsesValue
+	}
+
+	roles, err := parseIdList(c.FormValue("roles"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, "Role IDs must be integers.")
+	}
+	if len(roles) > 0 {
+		//pre-assigning roles is handing them out
+		if err := ensurePermissions(c, accountId, circleId, PERM_EDIT_ROLE_MEMBERS); err != nil {
+			return err
+		}
+		top, err := GetAccountTopRoleRank(accountId, circleId)
+		if err != nil {
+			c.Logger().Error(err)
+			return echo.NewHTTPError(http.StatusInternalServerError, "Failed to check role hierarchy.")
+		}
+		for _, roleId := range roles {
+			role, err := GetRoleInfo(roleId)
+			if err != nil {
+				c.Logger().Error(err)
+				return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get role info.")
+			} else if role == nil || role.CircleId != circleId {
+				return echo.NewHTTPError(http.StatusNotFound, "Role not found in this circle.")
+			} else if role.Name == ROLE_NAME_EVERYONE {
+				return echo.NewHTTPError(http.StatusUnprocessableEntity, "Members always get the " + ROLE_NAME_EVERYONE + " role.")
+			} else if !top.Above(role.Rank()) {
+				return echo.NewHTTPError(http.StatusForbidden, "Role must rank below your highest role.")
+			}
+		}
+	}
+
+	invite, err := CreateInvite(circleId, accountId, expires, maxUses, roles)
+	if err != nil {
+		c.Logger().Error(err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to create invite.")
+	}
+
+	jsonData, err := json.Marshal(collectInviteData(&invite))
+	if err != nil {
+		c.Logger().Error(err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to format invite data.")
+	}
+	return c.JSONBlob(http.StatusCreated, jsonData)
+}
+
+//GET /api/invite/:code
+func RouteApiInvite(c echo.Context) error {
+	_, _, err := getContextIds(c)
+	if err != nil {
+		return err
+	}
+
+	invite, err := GetInvite(c.Param("code"))
+	if err != nil {
+		c.Logger().Error(err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get invite.")
+	} else if invite == nil || !invite.Usable(time.Now()) {
+		return echo.NewHTTPError(http.StatusNotFound, "Invite not found.")
+	}
+	info, err := GetCircleInfo(invite.CircleId)
+	if err != nil {
+		c.Logger().Error(err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get circle info.")
+	} else if info == nil {
+		return echo.NewHTTPError(http.StatusNotFound, "Invite not found.")
+	}
+
+	//anyone with the code can see where it leads, but not who made it or how it's configured
+	jsonData, err := json.Marshal(map[string]interface{}{
+		"code": invite.Code,
+		"circle": map[string]interface{}{
+			"id": info.Id,
+			"name": info.Name,
+		},
+	})
+	if err != nil {
+		c.Logger().Error(err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to format invite data.")
+	}
+	return c.JSONBlob(http.StatusOK, jsonData)
+}
+
+//POST /api/invite/:code/join
+func RouteApiInviteJoin(c echo.Context) error {
+	_, accountId, err := getContextIds(c)
+	if err != nil {
+		return err
+	}
+
+	circleId, memberId, err := JoinCircleWithInvite(accountId, c.Param("code"))
+	if err != nil {
+		switch err.(type) {
+		case *InvalidInviteError:
+			return echo.NewHTTPError(http.StatusNotFound, "Invite not found.")
+		case *AlreadyMemberError:
+			return echo.NewHTTPError(http.StatusConflict, "Already a member of this circle.")
+		case *BannedError:
+			return echo.NewHTTPError(http.StatusForbidden, "Banned from this circle.")
+		}
+		c.Logger().Error(err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to join circle.")
+	}
+	EventHub.Publish(circleId, EVENT_MEMBER_JOIN, map[string]interface{}{"id": memberId, "account_id": accountId})
+
+	jsonData, err := json.Marshal(map[string]interface{}{"id": memberId, "circle_id": circleId})
+	if err != nil {
+		c.Logger().Error(err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to format member data.")
+	}
+	return c.JSONBlob(http.StatusOK, jsonData)
+}
+
+//DELETE /api/invite/:code
+func RouteApiInviteRevoke(c echo.Context) error {
+	_, accountId, err := getContextIds(c)
+	if err != nil {
+		return err
+	}
+
+	invite, err := GetInvite(c.Param("code"))
+	if err != nil {
+		c.Logger().Error(err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get invite.")
+	} else if invite == nil {
+		return echo.NewHTTPError(http.StatusNotFound, "Invite not found.")
+	}
+	if err := ensurePermissions(c, accountId, invite.CircleId, PERM_INVITE_CIRCLE_MEMBERS); err != nil {
+		return err
+	}
+
+	if err := RevokeInvite(invite.Id); err != nil {
+		c.Logger().Error(err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to revoke invite.")
+	}
+	invite.Revoked = true
+
+	jsonData, err := json.Marshal(collectInviteData(invite))
+	if err != nil {
+		c.Logger().Error(err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to format invite data.")
+	}
+	return c.JSONBlob(http.StatusOK, jsonData)
+}
+
+//parses the account a moderation action targets, which can't be the caller, the circle's owner or anyone ranked at or above the caller
+func getModerationTarget(c echo.Context, accountId AccountId, circleId CircleId, accountString string) (AccountId, error) {
+	targetId, err := strconv.ParseInt(accountString, 10, 64)
+	if err != nil {
+		return 0, echo.NewHTTPError(http.StatusUnprocessableEntity, "Account ID must be an integer.")
+	} else if targetId == accountId {
+		return 0, echo.NewHTTPError(http.StatusUnprocessableEntity, "Cannot target yourself.")
+	}
+	//owners of parent circles are owners here too
+	ownedId, err := GetOwnedCircle(targetId, circleId)
+	if err != nil {
+		c.Logger().Error(err)
+		return 0, echo.NewHTTPError(http.StatusInternalServerError, "Failed to get circle info.")
+	} else if ownedId != 0 {
+		return 0, echo.NewHTTPError(http.StatusForbidden, "Cannot target the circle's owner.")
+	}
+	//moderators can only act on members ranked strictly below them
+	targetTop, err := GetAccountTopRoleRank(targetId, circleId)
+	if err != nil {
+		c.Logger().Error(err)
+		return 0, echo.NewHTTPError(http.StatusInternalServerError, "Failed to check role hierarchy.")
+	}
+	ok, err := CanManageRoleRank(accountId, circleId, targetTop)
+	if err != nil {
+		c.Logger().Error(err)
+		return 0, echo.NewHTTPError(http.StatusInternalServerError, "Failed to check role hierarchy.")
+	} else if !ok {
+		return 0, echo.NewHTTPError(http.StatusForbidden, "Target must rank below your highest role.")
+	}
+	return targetId, nil
+}
+
+//parses an optional duration in seconds into an expiry time
+func parseExpiry(durationString string) (*time.Time, error) {
+	if len(durationString) < 1 {
+		return nil, nil
+	}
+	duration, err := strconv.ParseInt(durationString, 10, 64)
+	if err != nil || duration < 1 {
+		return nil, echo.NewHTTPError(http.StatusUnprocessableEntity, "Duration must be a positive number of seconds.")
+	}
+	expires := time.Now().Add(time.Duration(duration) * time.Second)
+	return &expires, nil
+}
+
+func formatOptionalTime(t *time.Time) *string {
+	if t == nil {
+		return nil
+	}
+	formatted := t.Format(time.RFC3339)
+	return &formatted
+}
+
+func collectRestrictionData(restriction *MemberRestriction) map[string]interface{} {
+	return map[string]interface{}{
+		"circle_id": restriction.CircleId,
+		"account_id": restriction.AccountId,
+		"username": restriction.Username,
+		"issuer_id": restriction.IssuerId,
+		"reason": restriction.Reason,
+		"created": restriction.Created.Format(time.RFC3339),
+		"expires": formatOptionalTime(restriction.Expires),
+	}
+}
+
+func restrictionListResponse(c echo.Context, restrictions []MemberRestriction) error {
+	restrictionDatas := make([]map[string]interface{}, len(restrictions))
+	for i := range restrictions {
+		restrictionDatas[i] = collectRestrictionData(&restrictions[i])
+	}
+	jsonData, err := json.Marshal(restrictionDatas)
+	if err != nil {
+		c.Logger().Error(err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to format restriction data.")
+	}
+	return c.JSONBlob(http.StatusOK, jsonData)
+}
+
+//DELETE /api/circle/:circle/members/:account
+func RouteApiCircleMemberRemove(c echo.Context) error {
+	accountId, circleId, _ := getPermissionContext(c)
+
+	targetId, err := getModerationTarget(c, accountId, circleId, c.Param("account"))
+	if err != nil {
+		return err
+	}
+
+	if err := LeaveCircle(targetId, circleId, accountId); err != nil {
+		if err == sql.ErrNoRows {
+			return echo.NewHTTPError(http.StatusNotFound, "Account is not a member of this circle.")
+		}
+		c.Logger().Error(err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to remove member.")
+	}
+	EventHub.Publish(circleId, EVENT_MEMBER_LEAVE, map[string]interface{}{"account_id": targetId, "reason": "remove"})
+	return c.NoContent(http.StatusOK)
+}
+
+//GET /api/circle/:circle/bans
+func RouteApiCircleBans(c echo.Context) error {
+	_, circleId, _ := getPermissionContext(c)
+
+	bans, err := GetCircleBans(circleId)
+	if err != nil {
+		c.Logger().Error(err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get circle bans.")
+	}
+	return restrictionListResponse(c, bans)
+}
+
+//POST /api/circle/:circle/bans
+func RouteApiCircleBan(c echo.Context) error {
+	accountId, circleId, _ := getPermissionContext(c)
+
+	targetId, err := getModerationTarget(c, accountId, circleId, c.FormValue("account_id"))
+	if err != nil {
+		return err
+	}
+
+	reason := strings.TrimSpace(c.FormValue("reason"))
+	if len(reason) > 400 {
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, "Reason must be at most 400 characters.")
+	}
+	//no duration means the ban lasts until it's removed
+	expires, err := parseExpiry(c.FormValue("duration"))
+	if err != nil {
+		return err
+	}
+
+	ban, err := BanAccount(circleId, targetId, accountId, reason, expires)
+	if err != nil {
+		c.Logger().Error(err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to ban account.")
+	}
+	EventHub.Publish(circleId, EVENT_MEMBER_LEAVE, map[string]interface{}{"account_id": targetId, "reason": "ban"})
+
+	jsonData, err := json.Marshal(collectRestrictionData(&ban))
+	if err != nil {
+		c.Logger().Error(err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to format ban data.")
+	}
+	return c.JSONBlob(http.StatusCreated, jsonData)
+}
+
+//DELETE /api/circle/:circle/bans/:account
+func RouteApiCircleUnban(c echo.Context) error {
+	accountId, circleId, _ := getPermissionContext(c)
+
+	targetId, err := getModerationTarget(c, accountId, circleId, c.Param("account"))
+	if err != nil {
+		return err
+	}
+
+	removed, err := UnbanAccount(circleId, targetId, accountId)
+	if err != nil {
+		c.Logger().Error(err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to unban account.")
+	} else if !removed {
+		return echo.NewHTTPError(http.StatusNotFound, "Account is not banned from this circle.")
+	}
+	return c.NoContent(http.StatusOK)
+}
+
+//GET /api/circle/:circle/mutes
+func RouteApiCircleMutes(c echo.Context) error {
+	_, circleId, _ := getPermissionContext(c)
+
+	mutes, err := GetCircleMutes(circleId)
+	if err != nil {
+		c.Logger().Error(err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get circle mutes.")
+	}
+	return restrictionListResponse(c, mutes)
+}
+
+//POST /api/circle/:circle/mutes
+func RouteApiCircleMute(c echo.Context) error {
+	accountId, circleId, _ := getPermissionContext(c)
+
+	targetId, err := getModerationTarget(c, accountId, circleId, c.FormValue("account_id"))
+	if err != nil {
+		return err
+	}
+
+	reason := strings.TrimSpace(c.FormValue("reason"))
+	if len(reason) > 400 {
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, "Reason must be at most 400 characters.")
+	}
+	expires, err := parseExpiry(c.FormValue("duration"))
+	if err != nil {
+		return err
+	} else if expires == nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Missing form value: \"duration\"")
+	}
+
+	mute, err := MuteAccount(circleId, targetId, accountId, reason, *expires)
+	if err != nil {
+		c.Logger().Error(err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to mute account.")
+	}
+
+	jsonData, err := json.Marshal(collectRestrictionData(&mute))
+	if err != nil {
+		c.Logger().Error(err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to format mute data.")
+	}
+	return c.JSONBlob(http.StatusCreated, jsonData)
+}
+
+//DELETE /api/circle/:circle/mutes/:account
+func RouteApiCircleUnmute(c echo.Context) error {
+	accountId, circleId, _ := getPermissionContext(c)
+
+	targetId, err := getModerationTarget(c, accountId, circleId, c.Param("account"))
+	if err != nil {
+		return err
+	}
+
+	removed, err := UnmuteAccount(circleId, targetId, accountId)
+	if err != nil {
+		c.Logger().Error(err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to unmute account.")
+	} else if !removed {
+		return echo.NewHTTPError(http.StatusNotFound, "Account is not muted in this circle.")
+	}
+	return c.NoContent(http.StatusOK)
+}
+
+func parseRoleColor(colorString string) ([]byte, error) {
+	color, err := hex.DecodeString(strings.TrimPrefix(colorString, "#"))
+	if err != nil || len(color) != 3 {
+		return nil, echo.NewHTTPError(http.StatusUnprocessableEntity, "Color must be 6 hex digits.")
+	}
+	return color, nil
+}
+
+func validateRoleName(name string) error {
+	if l := len(name); l < 1 || l > 32 {
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, "Role name must be between 1 and 32 characters.")
+	} else if strings.HasPrefix(name, "::") {
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, "Role names starting with \"::\" are reserved.")
+	}
+	return nil
+}
+
+//responds with an error if the account's highest role doesn't rank above the rank
+func ensureRoleRankedBelow(c echo.Context, accountId AccountId, circleId CircleId, rank RoleRank) error {
+	ok, err := CanManageRoleRank(accountId, circleId, rank)
+	if err != nil {
+		c.Logger().Error(err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to check role hierarchy.")
+	} else if !ok {
+		return echo.NewHTTPError(http.StatusForbidden, "Role must rank below your highest role.")
+	}
+	return nil
+}
+
+//gets the :role param, making sure it belongs to the :circle param
+func getCircleRoleParam(c echo.Context, circleId CircleId) (*RoleInfo, error) {
+	roleId, err := strconv.ParseInt(c.Param("role"), 10, 64)
+	if err != nil {
+		return nil, echo.NewHTTPError(http.StatusUnprocessableEntity, "Role ID must be an integer.")
+	}
+	role, err := GetRoleInfo(roleId)
+	if err != nil {
+		c.Logger().Error(err)
+		return nil, echo.NewHTTPError(http.StatusInternalServerError, "Failed to get role info.")
+	} else if role == nil || role.CircleId != circleId {
+		return nil, echo.NewHTTPError(http.StatusNotFound, "Role not found in this circle.")
+	}
+	return role, nil
+}
+
+//GET /api/circle/:circle/roles/all
+func RouteApiCircleRolesAll(c echo.Context) error {
+	_, circleId, _ := getPermissionContext(c)
+
+	roles, err := GetCircleRoles(circleId)
+	if err != nil {
+		c.Logger().Error(err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get circle roles.")
+	}
+	roleDatas := make([]map[string]interface{}, len(roles))
+	for i := range roles {
+		roleDatas[i] = collectRoleData(&roles[i])
+	}
+
+	jsonData, err := json.Marshal(roleDatas)
+	if err != nil {
+		c.Logger().Error(err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to format role data.")
+	}
+	return c.JSONBlob(http.StatusOK, jsonData)
+}
+
+//POST /api/circle/:circle/roles
+func RouteApiCircleRoleCreate(c echo.Context) error {
+	accountId, circleId, _ := getPermissionContext(c)
+
+	role := RoleInfo{CircleId: circleId, Name: strings.TrimSpace(c.FormValue("name"))}
+	if err := validateRoleName(role.Name); err != nil {
+		return err
+	}
+	if colorString := c.FormValue("color"); len(colorString) > 0 {
+		color, err := parseRoleColor(colorString)
+		if err != nil {
+			return err
+		}
+		role.Color = color
+	}
+	//new roles go just above ::everyone unless placed somewhere else
+	role.Order = ROLE_ORDER_LOWEST - 1
+	if orderString := c.FormValue("order"); len(orderString) > 0 {
+		order, err := strconv.Atoi(orderString)
+		if err != nil || order >= ROLE_ORDER_LOWEST || order <= ROLE_ORDER_OWNER {
+			return echo.NewHTTPError(http.StatusUnprocessableEntity, "Order must be an integer ranking above " + ROLE_NAME_EVERYONE + ".")
+		}
+		role.Order = order
+	}
+	if err := ensureRoleRankedBelow(c, accountId, circleId, role.Rank()); err != nil {
+		return err
+	}
+
+	roleId, err := CreateRole(role, accountId)
+	if err != nil {
+		if _, ok := err.(*DuplicateRoleNameError); ok {
+			return echo.NewHTTPError(http.StatusConflict, "A role with this name already exists.")
+		}
+		c.Logger().Error(err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to create role.")
+	}
+	role.Id = roleId
+	if role.Color == nil {
+		role.Color = append([]uint8(nil), DEFAULT_ROLE_COLOR...)
+	}
+
+	jsonData, err := json.Marshal(collectRoleData(&role))
+	if err != nil {
+		c.Logger().Error(err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to format role data.")
+	}
+	return c.JSONBlob(http.StatusCreated, jsonData)
+}
+
+//POST /api/circle/:circle/roles/:role
+func RouteApiCircleRoleEdit(c echo.Context) error {
+	accountId, circleId, _ := getPermissionContext(c)
+
+	role, err := getCircleRoleParam(c, circleId)
+	if err != nil {
+		return err
+	}
+
+	if err := c.Request().ParseForm(); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Malformed form data.")
+	}
+	var (
+		name *string = nil
+		color []byte = nil
+		required = make([]Permission, 0, 2)
+	)
+	if nameAll, ok := c.Request().PostForm["name"]; ok && len(nameAll) > 0 {
+		if role.Name == ROLE_NAME_EVERYONE {
+			return echo.NewHTTPError(http.StatusUnprocessableEntity, "The " + ROLE_NAME_EVERYONE + " role cannot be renamed.")
+		}
+		nameValue := strings.TrimSpace(nameAll[0])
+		if err := validateRoleName(nameValue); err != nil {
+			return err
+		}
+		name = &nameValue
+		required = append(required, PERM_EDIT_ROLE_NAME)
+	}
+	if colorAll, ok := c.Request().PostForm["color"]; ok && len(colorAll) > 0 {
+		if color, err = parseRoleColor(colorAll[0]); err != nil {
+			return err
+		}
+		required = append(required, PERM_EDIT_ROLE_COLOR)
+	}
+	if len(required) < 1 {
+		return echo.NewHTTPError(http.StatusBadRequest, "Missing form values: \"name\", \"color\"")
+	}
+	if err := ensurePermissions(c, accountId, circleId, required...); err != nil {
+		return err
+	}
+	if err := ensureRoleRankedBelow(c, accountId, circleId, role.Rank()); err != nil {
+		return err
+	}
+
+	if err := UpdateRole(role, name, color, accountId); err != nil {
+		if _, ok := err.(*DuplicateRoleNameError); ok {
+			return echo.NewHTTPError(http.StatusConflict, "A role with this name already exists.")
+		}
+		c.Logger().Error(err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to edit role.")
+	}
+
+	jsonData, err := json.Marshal(collectRoleData(role))
+	if err != nil {
+		c.Logger().Error(err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to format role data.")
+	}
+	return c.JSONBlob(http.StatusOK, jsonData)
+}
+
+//DELETE /api/circle/:circle/roles/:role
+func RouteApiCircleRoleDelete(c echo.Context) error {
+	accountId, circleId, _ := getPermissionContext(c)
+
+	role, err := getCircleRoleParam(c, circleId)
+	if err != nil {
+		return err
+	} else if role.Name == ROLE_NAME_EVERYONE {
+		return echo.NewHTTPError(http.StatusForbidden, "The " + ROLE_NAME_EVERYONE + " role cannot be deleted.")
+	}
+	if err := ensureRoleRankedBelow(c, accountId, circleId, role.Rank()); err != nil {
+		return err
+	}
+
+	if err := DeleteRole(role.Id, accountId); err != nil {
+		c.Logger().Error(err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to delete role.")
+	}
+	return c.NoContent(http.StatusOK)
+}
+
+//POST /api/circle/:circle/roles/order
+func RouteApiCircleRolesReorder(c echo.Context) error {
+	accountId, circleId, _ := getPermissionContext(c)
+
+	roles, err := parseIdList(c.FormValue("roles"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, "Role IDs must be integers.")
+	} else if len(roles) < 1 {
+		return echo.NewHTTPError(http.StatusBadRequest, "Missing form value: \"roles\"")
+	}
+	top, err := GetAccountTopRoleRank(accountId, circleId)
+	if err != nil {
+		c.Logger().Error(err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to check role hierarchy.")
+	}
+	for _, roleId := range roles {
+		role, err := GetRoleInfo(roleId)
+		if err != nil {
+			c.Logger().Error(err)
+			return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get role info.")
+		} else if role == nil || role.CircleId != circleId {
+			return echo.NewHTTPError(http.StatusNotFound, "Role not found in this circle.")
+		} else if role.Name == ROLE_NAME_EVERYONE {
+			return echo.NewHTTPError(http.StatusUnprocessableEntity, "The " + ROLE_NAME_EVERYONE + " role is always ranked lowest.")
+		} else if !top.Above(role.Rank()) {
+			return echo.NewHTTPError(http.StatusForbidden, "Role must rank below your highest role.")
+		}
+	}
+
+	if err := ReorderRoles(circleId, roles, accountId); err != nil {
+		if _, ok := err.(*RoleHierarchyError); ok {
+			return echo.NewHTTPError(http.StatusUnprocessableEntity, "Role IDs must be unique.")
+		}
+		c.Logger().Error(err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to reorder roles.")
+	}
+
+	circleRoles, err := GetCircleRoles(circleId)
+	if err != nil {
+		c.Logger().Error(err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get circle roles.")
+	}
+	roleDatas := make([]map[string]interface{}, len(circleRoles))
+	for i := range circleRoles {
+		roleDatas[i] = collectRoleData(&circleRoles[i])
+	}
+	jsonData, err := json.Marshal(roleDatas)
+	if err != nil {
+		c.Logger().Error(err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to format role data.")
+	}
+	return c.JSONBlob(http.StatusOK, jsonData)
+}
+
+func collectRoleMemberData(member *RoleMemberInfo) map[string]interface{} {
+	return map[string]interface{}{
+		"id": member.MemberId,
+		"account_id": member.AccountId,
+		"username": member.Username,
+		"assigned_by": member.AssignedBy,
+		"joined": member.Joined.Format(time.RFC3339),
+	}
+}
+
+//GET /api/circle/:circle/roles/:role/members
+func RouteApiCircleRoleMembers(c echo.Context) error {
+	_, circleId, _ := getPermissionContext(c)
+
+	role, err := getCircleRoleParam(c, circleId)
+	if err != nil {
+		return err
+	}
+
+	members, err := GetRoleMembers(role.Id)
+	if err != nil {
+		c.Logger().Error(err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get role members.")
+	}
+	memberDatas := make([]map[string]interface{}, len(members))
+	for i := range members {
+		memberDatas[i] = collectRoleMemberData(&members[i])
+	}
+
+	jsonData, err := json.Marshal(memberDatas)
+	if err != nil {
+		c.Logger().Error(err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to format role member data.")
+	}
+	return c.JSONBlob(http.StatusOK, jsonData)
+}
+
+//gets the role a member change is for, checking PERM_EDIT_ROLE_MEMBERS and the role hierarchy
+func getAssignableRole(c echo.Context, accountId AccountId, circleId CircleId) (*RoleInfo, error) {
+	if err := ensurePermissions(c, accountId, circleId, PERM_EDIT_ROLE_MEMBERS); err != nil {
+		return nil, err
+	}
+	role, err := getCircleRoleParam(c, circleId)
+	if err != nil {
+		return nil, err
+	} else if role.Name == ROLE_NAME_EVERYONE {
+		return nil, echo.NewHTTPError(http.StatusUnprocessableEntity, "Members always have the " + ROLE_NAME_EVERYONE + " role.")
+	}
+	if err := ensureRoleRankedBelow(c, accountId, circleId, role.Rank()); err != nil {
+		return nil, err
+	}
+	return role, nil
+}
+
+//POST /api/circle/:circle/roles/:role/members
+func RouteApiCircleRoleMembersAdd(c echo.Context) error {
+	accountId, circleId, _ := getPermissionContext(c)
+
+	role, err := getAssignableRole(c, accountId, circleId)
+	if err != nil {
+		return err
+	}
+
+	accounts, err := parseIdList(c.FormValue("accounts"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, "Account IDs must be integers.")
+	} else if len(accounts) < 1 {
+		return echo.NewHTTPError(http.StatusBadRequest, "Missing form value: \"accounts\"")
+	}
+	members := make([]MemberId, len(accounts))
+	for i, targetId := range accounts {
+		memberId, err := GetCircleMemberId(targetId, circleId)
+		if err != nil {
+			c.Logger().Error(err)
+			return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get circle member.")
+		} else if memberId == 0 {
+			return echo.NewHTTPError(http.StatusNotFound, fmt.Sprintf("Account %d is not a member of this circle.", targetId))
+		}
+		members[i] = memberId
+	}
+
+	added, err := AddRoleMembers(role.Id, members, accountId)
+	if err != nil {
+		c.Logger().Error(err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to add role members.")
+	}
+
+	jsonData, err := json.Marshal(map[string]interface{}{"role_id": role.Id, "added": added})
+	if err != nil {
+		c.Logger().Error(err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to format role member data.")
+	}
+	return c.JSONBlob(http.StatusOK, jsonData)
+}
+
+//DELETE /api/circle/:circle/roles/:role/members/:account
+func RouteApiCircleRoleMemberRemove(c echo.Context) error {
+	accountId, circleId, _ := getPermissionContext(c)
+
+	role, err := getAssignableRole(c, accountId, circleId)
+	if err != nil {
+		return err
+	}
+	targetId, err := strconv.ParseInt(c.Param("account"), 10, 64)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, "Account ID must be an integer.")
+	}
+	memberId, err := GetCircleMemberId(targetId, circleId)
+	if err != nil {
+		c.Logger().Error(err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get circle member.")
+	} else if memberId == 0 {
+		return echo.NewHTTPError(http.StatusNotFound, "Account is not a member of this circle.")
+	}
+
+	removed, err := RemoveRoleMember(role.Id, memberId, accountId)
+	if err != nil {
+		c.Logger().Error(err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to remove role member.")
+	} else if !removed {
+		return echo.NewHTTPError(http.StatusNotFound, "Account does not have this role.")
+	}
+	return c.NoContent(http.StatusOK)
+}
+
+const (
+	PERMISSION_STATE_GRANT = "grant"
+	PERMISSION_STATE_DENY = "deny"
+	PERMISSION_STATE_INHERIT = "inherit"
+)
+
+//gets the :role param for permission changes, which can be a role from the circle or any of its parents
+func getPermissionRoleParam(c echo.Context, circleId CircleId) (*RoleInfo, error) {
+	roleId, err := strconv.ParseInt(c.Param("role"), 10, 64)
+	if err != nil {
+		return nil, echo.NewHTTPError(http.StatusUnprocessableEntity, "Role ID must be an integer.")
+	}
+	ok, err := IsRoleInCircleTree(roleId, circleId)
+	if err != nil {
+		c.Logger().Error(err)
+		return nil, echo.NewHTTPError(http.StatusInternalServerError, "Failed to check role.")
+	} else if !ok {
+		return nil, echo.NewHTTPError(http.StatusNotFound, "Role not found in this circle.")
+	}
+	role, err := GetRoleInfo(roleId)
+	if err != nil || role == nil {
+		if err != nil {
+			c.Logger().Error(err)
+		}
+		return nil, echo.NewHTTPError(http.StatusInternalServerError, "Failed to get role info.")
+	}
+	return role, nil
+}
+
+//gets the state of every permission, unknown numbers that are set are kept so they can be seen and cleared
+func collectPermissionStates(explicit PermissionsList) map[string]string {
+	all := PermissionRegistry.All()
+	states := make(map[string]string, len(all))
+	for _, p := range all {
+		states[p.Name] = PERMISSION_STATE_INHERIT
+	}
+	for n, granted := range explicit {
+		if granted {
+			states[PermissionRegistry.Get(n).Name] = PERMISSION_STATE_GRANT
+		} else {
+			states[PermissionRegistry.Get(n).Name] = PERMISSION_STATE_DENY
+		}
+	}
+	return states
+}
+
+func rolePermissionsResponse(c echo.Context, role *RoleInfo, circleId CircleId) error {
+	explicit, err := GetRolePermissions(role.Id, circleId)
+	if err != nil {
+		c.Logger().Error(err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get role permissions.")
+	}
+	effective, err := GetAllPermissions(circleId, role.Id)
+	if err != nil {
+		c.Logger().Error(err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get effective role permissions.")
+	}
+
+	states := collectPermissionStates(explicit)
+
+	jsonData, err := json.Marshal(map[string]interface{}{
+		"role_id": role.Id,
+		"circle_id": circleId,
+		"permissions": states,
+		"effective": collectPermissionsData(effective),
+	})
+	if err != nil {
+		c.Logger().Error(err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to format role permission data.")
+	}
+	return c.JSONBlob(http.StatusOK, jsonData)
+}
+
+//GET /api/circle/:circle/permissions/:role
+func RouteApiCircleRolePermissions(c echo.Context) error {
+	_, circleId, _ := getPermissionContext(c)
+
+	role, err := getPermissionRoleParam(c, circleId)
+	if err != nil {
+		return err
+	}
+	return rolePermissionsResponse(c, role, circleId)
+}
+
+//reads the grant, deny and inherit form values into changes, nil meaning inherit
+func parsePermissionStates(c echo.Context, accountId AccountId, circleId CircleId) (map[PermissionNumber]*bool, error) {
+	grantValue, denyValue := true, false
+	changes := make(map[PermissionNumber]*bool)
+	for state, value := range map[string]*bool{
+		PERMISSION_STATE_GRANT: &grantValue,
+		PERMISSION_STATE_DENY: &denyValue,
+		PERMISSION_STATE_INHERIT: nil,
+	} {
+		numbers, err := parsePermissionNames(c.FormValue(state))
+		if err != nil {
+			return nil, err
+		}
+		if value != nil {
+			if err := rejectUnknownPermissions(numbers); err != nil {
+				return nil, err
+			}
+		}
+		for _, n := range numbers {
+			if _, ok := changes[n]; ok {
+				return nil, echo.NewHTTPError(http.StatusUnprocessableEntity, "Permission given more than one state: " + PermissionRegistry.Get(n).Name)
+			}
+			changes[n] = value
+		}
+	}
+	if len(changes) < 1 {
+		return nil, echo.NewHTTPError(http.StatusBadRequest, "Missing form values: \"grant\", \"deny\", \"inherit\"")
+	}
+	//permissions can only be granted or denied by someone who has them, and only administrators can touch administrator at all
+	required := make([]Permission, 0, len(changes))
+	for n, value := range changes {
+		if value != nil || n == PERM_ADMINISTRATOR.Number {
+			required = append(required, PermissionRegistry.Get(n))
+		}
+	}
+	sort.Slice(required, func(i, j int) bool {
+		return required[i].Number < required[j].Number
+	})
+	if err := ensurePermissions(c, accountId, circleId, required...); err != nil {
+		return nil, err
+	}
+	return changes, nil
+}
+
+//POST /api/circle/:circle/permissions/:role
+func RouteApiCircleRolePermissionsEdit(c echo.Context) error {
+	accountId, circleId, _ := getPermissionContext(c)
+
+	role, err := getPermissionRoleParam(c, circleId)
+	if err != nil {
+		return err
+	}
+	//roles are ranked within the circle they belong to
+	if err := ensureRoleRankedBelow(c, accountId, role.CircleId, role.Rank()); err != nil {
+		return err
+	}
+
+	changes, err := parsePermissionStates(c, accountId, circleId)
+	if err != nil {
+		return err
+	}
+
+	if err := SetRolePermissions(role.Id, circleId, changes, accountId); err != nil {
+		c.Logger().Error(err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to change role permissions.")
+	}
+	return rolePermissionsResponse(c, role, circleId)
+}
+
+//gets the member whose overrides are being viewed or edited, the account needs to rank above them
+func getPermissionMemberParam(c echo.Context, accountId AccountId, circleId CircleId) (AccountId, MemberId, error) {
+	targetId, err := strconv.ParseInt(c.Param("account"), 10, 64)
+	if err != nil {
+		return 0, 0, echo.NewHTTPError(http.StatusUnprocessableEntity, "Account ID must be an integer.")
+	}
+	memberId, err := GetCircleMemberId(targetId, circleId)
+	if err != nil {
+		c.Logger().Error(err)
+		return 0, 0, echo.NewHTTPError(http.StatusInternalServerError, "Failed to get member.")
+	} else if memberId == 0 {
+		return 0, 0, echo.NewHTTPError(http.StatusNotFound, "Account is not a member of this circle.")
+	}
+	targetTop, err := GetAccountTopRoleRank(targetId, circleId)
+	if err != nil {
+		c.Logger().Error(err)
+		return 0, 0, echo.NewHTTPError(http.StatusInternalServerError, "Failed to check role hierarchy.")
+	}
+	if err := ensureRoleRankedBelow(c, accountId, circleId, targetTop); err != nil {
+		return 0, 0, err
+	}
+	return targetId, memberId, nil
+}
+
+func memberPermissionsResponse(c echo.Context, targetId AccountId, memberId MemberId, circleId CircleId) error {
+	explicit, err := GetMemberPermissions(memberId)
+	if err != nil {
+		c.Logger().Error(err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get member permissions.")
+	}
+	effective, err := ExplainAccountPermissions(targetId, circleId)
+	if err != nil {
+		c.Logger().Error(err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get effective member permissions.")
+	}
+
+	states := collectPermissionStates(explicit)
+
+	jsonData, err := json.Marshal(map[string]interface{}{
+		"account_id": targetId,
+		"member_id": memberId,
+		"circle_id": circleId,
+		"permissions": states,
+		"effective": collectPermissionSourceData(effective),
+	})
+	if err != nil {
+		c.Logger().Error(err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to format member permission data.")
+	}
+	return c.JSONBlob(http.StatusOK, jsonData)
+}
+
+//GET /api/circle/:circle/members/:account/permissions
+func RouteApiCircleMemberPermissions(c echo.Context) error {
+	accountId, circleId, _ := getPermissionContext(c)
+
+	targetId, memberId, err := getPermissionMemberParam(c, accountId, circleId)
+	if err != nil {
+		return err
+	}
+	return memberPermissionsResponse(c, targetId, memberId, circleId)
+}
+
+//POST /api/circle/:circle/members/:account/permissions
+func RouteApiCircleMemberPermissionsEdit(c echo.Context) error {
+	accountId, circleId, _ := getPermissionContext(c)
+
+	targetId, memberId, err := getPermissionMemberParam(c, accountId, circleId)
+	if err != nil {
+		return err
+	}
+	changes, err := parsePermissionStates(c, accountId, circleId)
+	if err != nil {
+		return err
+	}
+
+	if err := SetMemberPermissions(memberId, changes, accountId); err != nil {
+		c.Logger().Error(err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to change member permissions.")
+	}
+	return memberPermissionsResponse(c, targetId, memberId, circleId)
+}
+
+func collectPermissionSourceData(resolved ResolvedPermissions) map[string]map[string]interface{} {
+	permissionData := make(map[string]map[string]interface{}, len(resolved))
+	for n, source := range resolved {
+		p := PermissionRegistry.Get(n)
+		sourceData := map[string]interface{}{
+			"display_name": p.DisplayName,
+			"granted": source.Granted,
+			"reason": source.Reason,
+			"circle_id": source.CircleId,
+			"inherited": source.Inherited,
+		}
+		if source.Reason == PERMISSION_REASON_ROLE || source.Reason == PERMISSION_REASON_ADMINISTRATOR {
+			sourceData["role"] = map[string]interface{}{
+				"id": source.RoleId,
+				"name": source.RoleName,
+				"order": source.RoleOrder,
+			}
+		}
+		permissionData[p.Name] = sourceData
+	}
+	return permissionData
+}
+
+//GET /api/circle/:circle/permissions/explain?account&names
+func RouteApiCirclePermissionsExplain(c echo.Context) error {
+	accountId, circleId, _ := getPermissionContext(c)
+
+	//explaining someone else's permissions is for the people who manage them
+	targetId := accountId
+	if targetString := c.QueryParam("account"); len(targetString) > 0 {
+		parsedId, err := strconv.ParseInt(targetString, 10, 64)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusUnprocessableEntity, "Account ID must be an integer.")
+		}
+		targetId = parsedId
+		if targetId != accountId {
+			if err := ensurePermissions(c, accountId, circleId, PERM_EDIT_ROLE_PERMISSIONS); err != nil {
+				return err
+			}
+		}
+	}
+
+	resolved, err := ExplainAccountPermissions(targetId, circleId)
+	if err != nil {
+		c.Logger().Error(err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to resolve permissions.")
+	}
+	if permissionsString := c.QueryParam("names"); len(permissionsString) > 0 {
+		permissionNumbers, err := parsePermissionNames(permissionsString)
+		if err != nil {
+			return err
+		}
+		filtered := make(ResolvedPermissions, len(permissionNumbers))
+		for _, n := range permissionNumbers {
+			if source, ok := resolved[n]; ok {
+				filtered[n] = source
+			}
+		}
+		resolved = filtered
+	}
+
+	jsonData, err := json.Marshal(map[string]interface{}{
+		"account_id": targetId,
+		"circle_id": circleId,
+		"permissions": collectPermissionSourceData(resolved),
+	})
+	if err != nil {
+		c.Logger().Error(err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to format permission data.")
+	}
+	return c.JSONBlob(http.StatusOK, jsonData)
+}
+
+//GET /api/circle/:circle/permissions/simulate?account|roles
+func RouteApiCirclePermissionsSimulate(c echo.Context) error {
+	accountId, circleId, _ := getPermissionContext(c)
+
+	var (
+		targetId *AccountId = nil
+		roles []RoleId = nil
+	)
+	if targetString := c.QueryParam("account"); len(targetString) > 0 {
+		parsedId, err := strconv.ParseInt(targetString, 10, 64)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusUnprocessableEntity, "Account ID must be an integer.")
+		}
+		targetId = &parsedId
+	} else {
+		var err error
+		if roles, err = parseIdList(c.QueryParam("roles")); err != nil {
+			return echo.NewHTTPError(http.StatusUnprocessableEntity, "Role IDs must be integers.")
+		} else if len(roles) < 1 {
+			return echo.NewHTTPError(http.StatusBadRequest, "Missing query value: \"account\" or \"roles\"")
+		}
+		for _, roleId := range roles {
+			ok, err := IsRoleInCircleTree(roleId, circleId)
+			if err != nil {
+				c.Logger().Error(err)
+				return echo.NewHTTPError(http.StatusInternalServerError, "Failed to check role.")
+			} else if !ok {
+				return echo.NewHTTPError(http.StatusNotFound, fmt.Sprintf("Role %d not found in this circle.", roleId))
+			}
+		}
+	}
+
+	children, err := GetAllCircleChildren(circleId)
+	if err != nil {
+		c.Logger().Error(err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get subcircles.")
+	}
+	circleIds := append([]CircleId{circleId}, children...)
+	circleDatas := make([]map[string]interface{}, 0, len(circleIds))
+	visible := make([]CircleId, 0, len(circleIds))
+	for _, id := range circleIds {
+		info, err := GetCircleInfo(id)
+		if err != nil {
+			c.Logger().Error(err)
+			return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get circle info.")
+		} else if info == nil {
+			//in the trash
+			continue
+		}
+		//the preview doesn't show subcircles the account asking for it can't see
+		canView, err := CanViewCircle(accountId, id)
+		if err != nil {
+			c.Logger().Error(err)
+			return echo.NewHTTPError(http.StatusInternalServerError, "Failed to check circle permissions.")
+		} else if !canView {
+			circleDatas = append(circleDatas, redactedCircleData(id))
+			continue
+		}
+
+		var resolved ResolvedPermissions
+		if targetId != nil {
+			resolved, err = ExplainAccountPermissions(*targetId, id)
+		} else {
+			//every member has the ::everyone role of each circle they're in
+			var everyoneId RoleId
+			if everyoneId, err = GetEveryoneRole(id); err != nil {
+				c.Logger().Error(err)
+				return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get role info.")
+			}
+			circleRoles := roles[:len(roles):len(roles)]
+			if everyoneId != 0 {
+				circleRoles = append(circleRoles, everyoneId)
+			}
+			resolved, err = SimulateRolePermissions(id, circleRoles)
+		}
+		if err != nil {
+			c.Logger().Error(err)
+			return echo.NewHTTPError(http.StatusInternalServerError, "Failed to resolve permissions.")
+		}
+		permissions := FlattenPermissions(resolved)
+		if permissions[PERM_VIEW_CIRCLE.Number] {
+			visible = append(visible, id)
+		}
+		circleDatas = append(circleDatas, map[string]interface{}{
+			"id": id,
+			"parent_id": info.ParentId,
+			"name": info.Name,
+			"visible": permissions[PERM_VIEW_CIRCLE.Number],
+			"permissions": collectPermissionsData(permissions),
+		})
+	}
+
+	simulationData := map[string]interface{}{
+		"circle_id": circleId,
+		"visible": visible,
+		"circles": circleDatas,
+	}
+	if targetId != nil {
+		simulationData["account_id"] = *targetId
+	} else {
+		simulationData["roles"] = roles
+	}
+	jsonData, err := json.Marshal(simulationData)
+	if err != nil {
+		c.Logger().Error(err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to format permission data.")
+	}
+	return c.JSONBlob(http.StatusOK, jsonData)
+}
+
+//GET /api/permissions
+func RouteApiPermissions(c echo.Context) error {
+	all := PermissionRegistry.All()
+	permissionDatas := make([]map[string]interface{}, len(all))
+	for i, p := range all {
+		permissionDatas[i] = map[string]interface{}{
+			"number": p.Number,
+			"name": p.Name,
+			"display_name": p.DisplayName,
+		}
+	}
+
+	jsonData, err := json.Marshal(map[string]interface{}{
+		"permissions": permissionDatas,
+		"groups": PermissionRegistry.Groups(),
+	})
+	if err != nil {
+		c.Logger().Error(err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to format permission data.")
+	}
+	return c.JSONBlob(http.StatusOK, jsonData)
+}
+
+//parses the limit query value, using the default when it's missing
+func parsePageLimit(c echo.Context, defaultLimit int, maxLimit int) (int, error) {
+	limitString := c.QueryParam("limit")
+	if len(limitString) < 1 {
+		return defaultLimit, nil
+	}
+	limit, err := strconv.Atoi(limitString)
+	if err != nil || limit < 1 || limit > maxLimit {
+		return 0, echo.NewHTTPError(http.StatusUnprocessableEntity, fmt.Sprintf("Limit must be an integer from 1 to %d.", maxLimit))
+	}
+	return limit, nil
+}
+
+func collectAuditEntryData(entry *AuditEntry) map[string]interface{} {
+	return map[string]interface{}{
+		"id": entry.Id,
+		"circle_id": entry.CircleId,
+		"actor_id": entry.ActorId,
+		"action": entry.Action,
+		"target_id": entry.TargetId,
+		"before": entry.Before,
+		"after": entry.After,
+		"created": entry.Created.Format(time.RFC3339),
+	}
+}
+
+//GET /api/circle/:circle/audit?actor&target&actions&before&limit
+func RouteApiCircleAudit(c echo.Context) error {
+	_, circleId, _ := getPermissionContext(c)
+
+	filter := AuditFilter{}
+	for _, param := range []struct{
+		name string
+		dest **int64
+		message string
+	}{
+		{"actor", &filter.ActorId, "Actor ID must be an integer."},
+		{"target", &filter.TargetId, "Target ID must be an integer."},
+		{"before", &filter.Before, "Before must be an audit entry ID."},
+	} {
+		valueString := c.QueryParam(param.name)
+		if len(valueString) < 1 {
+			continue
+		}
+		value, err := strconv.ParseInt(valueString, 10, 64)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusUnprocessableEntity, param.message)
+		}
+		*param.dest = &value
+	}
+	if actionsString := c.QueryParam("actions"); len(actionsString) > 0 {
+		filter.Actions = strings.Split(actionsString, "+")
+	}
+	limit, err := parsePageLimit(c, AUDIT_PAGE_SIZE_DEFAULT, AUDIT_PAGE_SIZE_MAX)
+	if err != nil {
+		return err
+	}
+
+	entries, err := GetAuditLog(circleId, filter, limit)
+	if err != nil {
+		c.Logger().Error(err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get audit log.")
+	}
+	entryDatas := make([]map[string]interface{}, len(entries))
+	for i := range entries {
+		entryDatas[i] = collectAuditEntryData(&entries[i])
+	}
+	//a full page means there may be more, the next page starts before the last entry
+	var next *int64 = nil
+	if len(entries) == limit {
+		next = &entries[len(entries)-1].Id
+	}
+
+	jsonData, err := json.Marshal(map[string]interface{}{
+		"entries": entryDatas,
+		"next": next,
+	})
+	if err != nil {
+		c.Logger().Error(err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to format audit log data.")
+	}
+	return c.JSONBlob(http.StatusOK, jsonData)
+}
+
+//responds with an error if the circle doesn't use the communication type
+func ensureCircleComType(c echo.Context, circleId CircleId, comType CommunicationType) error {
+	info, err := GetCircleInfo(circleId)
+	if err != nil {
+		c.Logger().Error(err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get circle info.")
+	} else if info == nil {
+		return echo.NewHTTPError(http.StatusNotFound, "Circle not found.")
+	} else if info.ComType != comType {
+		if comType == COM_TYPE_POST {
+			return echo.NewHTTPError(http.StatusUnprocessableEntity, "Circle does not use posts.")
+		}
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, "Circle does not use messages.")
+	}
+	return nil
+}
+
+func validatePostTitle(title string) error {
+	if l := len(title); l < 1 || l > POST_TITLE_MAX_LENGTH {
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, fmt.Sprintf("Post title must be between 1 and %d characters.", POST_TITLE_MAX_LENGTH))
+	}
+	return nil
+}
+
+func validatePostBody(body string) error {
+	if l := len(body); l < 1 || l > POST_BODY_MAX_LENGTH {
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, fmt.Sprintf("Post body must be between 1 and %d characters.", POST_BODY_MAX_LENGTH))
+	}
+	return nil
+}
+
+//gets the :post param, making sure it's in the circle
+func getCirclePostParam(c echo.Context, circleId CircleId) (*PostInfo, error) {
+	return lookupCirclePostParam(c, circleId, false)
+}
+
+func lookupCirclePostParam(c echo.Context, circleId CircleId, includeDeleted bool) (*PostInfo, error) {
+	postId, err := strconv.ParseInt(c.Param("post"), 10, 64)
+	if err != nil {
+		return nil, echo.NewHTTPError(http.StatusUnprocessableEntity, "Post ID must be an integer.")
+	}
+	var post *PostInfo
+	if includeDeleted {
+		post, err = GetPostIncludeDeleted(postId)
+	} else {
+		post, err = GetPost(postId)
+	}
+	if err != nil {
+		c.Logger().Error(err)
+		return nil, echo.NewHTTPError(http.StatusInternalServerError, "Failed to get post.")
+	} else if post == nil || post.CircleId != circleId {
+		return nil, echo.NewHTTPError(http.StatusNotFound, "Post not found in this circle.")
+	}
+	return post, nil
+}
+
+func collectPostData(post *PostInfo) map[string]interface{} {
+	//unknown authors are null rather than an id that looks like an account's
+	var authorId *AccountId
+	if post.AuthorId != POST_AUTHOR_UNKNOWN {
+		authorId = &post.AuthorId
+	}
+	return map[string]interface{}{
+		"id": post.Id,
+		"circle_id": post.CircleId,
+		"author_id": authorId,
+		"author_name": post.AuthorName,
+		"created": post.Created.Format(time.RFC3339),
+		"edited": formatOptionalTime(post.Edited),
+		"deleted": formatOptionalTime(post.Deleted),
+		"title": post.Title,
+		"body": post.Body,
+	}
+}
+
+//GET /api/circle/:circle/posts?before&limit
+func RouteApiCirclePosts(c echo.Context) error {
+	_, circleId, _ := getPermissionContext(c)
+
+	if err := ensureCircleComType(c, circleId, COM_TYPE_POST); err != nil {
+		return err
+	}
+	var before *PostId = nil
+	if beforeString := c.QueryParam("before"); len(beforeString) > 0 {
+		beforeId, err := strconv.ParseInt(beforeString, 10, 64)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusUnprocessableEntity, "Before must be a post ID.")
+		}
+		before = &beforeId
+	}
+	limit, err := parsePageLimit(c, POST_PAGE_SIZE_DEFAULT, POST_PAGE_SIZE_MAX)
+	if err != nil {
+		return err
+	}
+
+	posts, err := GetCirclePosts(circleId, before, limit)
+	if err != nil {
+		c.Logger().Error(err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get posts.")
+	}
+	postDatas := make([]map[string]interface{}, len(posts))
+	for i := range posts {
+		postDatas[i] = collectPostData(&posts[i])
+	}
+	var next *PostId = nil
+	if len(posts) == limit {
+		next = &posts[len(posts)-1].Id
+	}
+
+	jsonData, err := json.Marshal(map[string]interface{}{
+		"posts": postDatas,
+		"next": next,
+	})
+	if err != nil {
+		c.Logger().Error(err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to format post data.")
+	}
+	return c.JSONBlob(http.StatusOK, jsonData)
+}
+
+//POST /api/circle/:circle/posts
+func RouteApiCirclePostCreate(c echo.Context) error {
+	accountId, circleId, _ := getPermissionContext(c)
+
+	if err := ensureCircleComType(c, circleId, COM_TYPE_POST); err != nil {
+		return err
+	}
+	title := strings.TrimSpace(c.FormValue("title"))
+	if err := validatePostTitle(title); err != nil {
+		return err
+	}
+	body := c.FormValue("body")
+	if err := validatePostBody(body); err != nil {
+		return err
+	}
+
+	post, err := CreatePost(circleId, accountId, title, body)
+	if err != nil {
+		c.Logger().Error(err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to create post.")
+	}
+	EventHub.Publish(circleId, EVENT_POST_NEW, collectPostData(&post))
+
+	jsonData, err := json.Marshal(collectPostData(&post))
+	if err != nil {
+		c.Logger().Error(err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to format post data.")
+	}
+	return c.JSONBlob(http.StatusCreated, jsonData)
+}
+
+//GET /api/circle/:circle/posts/:post
+func RouteApiCirclePost(c echo.Context) error {
+	_, circleId, _ := getPermissionContext(c)
+
+	post, err := getCirclePostParam(c, circleId)
+	if err != nil {
+		return err
+	}
+
+	jsonData, err := json.Marshal(collectPostData(post))
+	if err != nil {
+		c.Logger().Error(err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to format post data.")
+	}
+	return c.JSONBlob(http.StatusOK, jsonData)
+}
+
+//POST /api/circle/:circle/posts/:post
+func RouteApiCirclePostEdit(c echo.Context) error {
+	accountId, circleId, _ := getPermissionContext(c)
+
+	post, err := getCirclePostParam(c, circleId)
+	if err != nil {
+		return err
+	} else if post.AuthorId != accountId {
+		return echo.NewHTTPError(http.StatusForbidden, "Only the author can edit a post.")
+	}
+
+	if err := c.Request().ParseForm(); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Malformed form data.")
+	}
+	var title, body *string = nil, nil
+	if titleAll, ok := c.Request().PostForm["title"]; ok && len(titleAll) > 0 {
+		titleValue := strings.TrimSpace(titleAll[0])
+		if err := validatePostTitle(titleValue); err != nil {
+			return err
+		}
+		title = &titleValue
+	}
+	if bodyAll, ok := c.Request().PostForm["body"]; ok && len(bodyAll) > 0 {
+		if err := validatePostBody(bodyAll[0]); err != nil {
+			return err
+		}
+		body = &bodyAll[0]
+	}
+	if title == nil && body == nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Missing form values: \"title\", \"body\"")
+	}
+
+	if err := EditPost(post, title, body); err != nil {
+		c.Logger().Error(err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to edit post.")
+	}
+	EventHub.Publish(circleId, EVENT_POST_EDIT, collectPostData(post))
+
+	jsonData, err := json.Marshal(collectPostData(post))
+	if err != nil {
+		c.Logger().Error(err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to format post data.")
+	}
+	return c.JSONBlob(http.StatusOK, jsonData)
+}
+
+//DELETE /api/circle/:circle/posts/:post
+func RouteApiCirclePostDelete(c echo.Context) error {
+	accountId, circleId, permissions := getPermissionContext(c)
+
+	post, err := getCirclePostParam(c, circleId)
+	if err != nil {
+		return err
+	}
+	//authors can delete their own posts with either permission
+	if post.AuthorId != accountId || !permissions[PERM_DELETE_OWN_CONTENT.Number] {
+		if err := ensurePermissions(c, accountId, circleId, PERM_DELETE_CONTENT); err != nil {
+			return err
+		}
+	}
+
+	if err := DeletePost(post.Id); err != nil {
+		c.Logger().Error(err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to delete post.")
+	}
+	EventHub.Publish(circleId, EVENT_POST_DELETE, map[string]interface{}{"id": post.Id})
+	return c.NoContent(http.StatusOK)
+}
+
+//GET /api/circle/:circle/posts/:post/revisions
+func RouteApiCirclePostRevisions(c echo.Context) error {
+	_, circleId, _ := getPermissionContext(c)
+
+	//revisions are kept so deleted posts can still be looked into
+	post, err := lookupCirclePostParam(c, circleId, true)
+	if err != nil {
+		return err
+	}
+
+	revisions, err := GetPostRevisions(post.Id)
+	if err != nil {
+		c.Logger().Error(err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get post revisions.")
+	}
+	//each revision was written when the one before it was replaced
+	written := post.Created
+	revisionDatas := make([]map[string]interface{}, len(revisions))
+	for i, revision := range revisions {
+		revisionDatas[i] = map[string]interface{}{
+			"id": revision.Id,
+			"title": revision.Title,
+			"body": revision.Body,
+			"written": written.Format(time.RFC3339),
+			"replaced": revision.Created.Format(time.RFC3339),
+		}
+		written = revision.Created
+	}
+
+	jsonData, err := json.Marshal(map[string]interface{}{
+		"post": collectPostData(post),
+		"revisions": revisionDatas,
+	})
+	if err != nil {
+		c.Logger().Error(err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to format post revision data.")
+	}
+	return c.JSONBlob(http.StatusOK, jsonData)
+}
+
+func collectMessageData(message *MessageInfo) map[string]interface{} {
+	var reply map[string]interface{} = nil
+	if message.Reply != nil {
+		reply = map[string]interface{}{
+			"id": message.Reply.Id,
+			"author_id": message.Reply.AuthorId,
+			"author_name": message.Reply.AuthorName,
+			"body": message.Reply.Body,
+		}
+	}
+	return map[string]interface{}{
+		"id": message.Id,
+		"circle_id": message.CircleId,
+		"author_id": message.AuthorId,
+		"author_name": message.AuthorName,
+		"reply_id": message.ReplyId,
+		"reply": reply,
+		"created": message.Created.Format(time.RFC3339),
+		"edited": formatOptionalTime(message.Edited),
+		"deleted": formatOptionalTime(message.Deleted),
+		"body": message.Body,
+	}
+}
+
+//GET /api/circle/:circle/messages?before|after|around&limit
+func RouteApiCircleMessages(c echo.Context) error {
+	_, circleId, _ := getPermissionContext(c)
+
+	if err := ensureCircleComType(c, circleId, COM_TYPE_MESSAGE); err != nil {
+		return err
+	}
+	limit, err := parsePageLimit(c, MESSAGE_PAGE_SIZE_DEFAULT, MESSAGE_PAGE_SIZE_MAX)
+	if err != nil {
+		return err
+	}
+	var (
+		cursorName string
+		cursor MessageId
+	)
+	for _, name := range []string{"before", "after", "around"} {
+		cursorString := c.QueryParam(name)
+		if len(cursorString) < 1 {
+			continue
+		} else if len(cursorName) > 0 {
+			return echo.NewHTTPError(http.StatusBadRequest, "Only one of \"before\", \"after\" and \"around\" can be given.")
+		}
+		if cursor, err = strconv.ParseInt(cursorString, 10, 64); err != nil {
+			return echo.NewHTTPError(http.StatusUnprocessableEntity, "Cursor must be a message ID.")
+		}
+		cursorName = name
+	}
+
+	var messages []MessageInfo
+	switch cursorName {
+	case "before":
+		messages, err = GetMessagesBefore(circleId, &cursor, limit)
+	case "after":
+		messages, err = GetMessagesAfter(circleId, cursor, limit)
+	case "around":
+		messages, err = GetMessagesAround(circleId, cursor, limit)
+	default:
+		messages, err = GetMessagesBefore(circleId, nil, limit)
+	}
+	if err != nil {
+		c.Logger().Error(err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get messages.")
+	}
+	messageDatas := make([]map[string]interface{}, len(messages))
+	for i := range messages {
+		messageDatas[i] = collectMessageData(&messages[i])
+	}
+
+	jsonData, err := json.Marshal(messageDatas)
+	if err != nil {
+		c.Logger().Error(err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to format message data.")
+	}
+	return c.JSONBlob(http.StatusOK, jsonData)
+}
+
+//POST /api/circle/:circle/messages
+func RouteApiCircleMessageSend(c echo.Context) error {
+	accountId, circleId, _ := getPermissionContext(c)
+
+	if err := ensureCircleComType(c, circleId, COM_TYPE_MESSAGE); err != nil {
+		return err
+	}
+	body := c.FormValue("body")
+	if err := validateMessageBody(body); err != nil {
+		return err
+	}
+	var reply *MessageId = nil
+	if replyString := c.FormValue("reply_id"); len(replyString) > 0 {
+		replyId, err := strconv.ParseInt(replyString, 10, 64)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusUnprocessableEntity, "Reply ID must be an integer.")
+		}
+		replied, err := GetMessage(replyId)
+		if err != nil {
+			c.Logger().Error(err)
+			return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get message.")
+		} else if replied == nil || replied.CircleId != circleId {
+			return echo.NewHTTPError(http.StatusNotFound, "Replied to message not found in this circle.")
+		}
+		reply = &replyId
+	}
+
+	message, err := SendMessage(circleId, accountId, body, reply)
+	if err != nil {
+		c.Logger().Error(err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to send message.")
+	}
+	EventHub.Publish(circleId, EVENT_MESSAGE_NEW, collectMessageData(message))
+
+	jsonData, err := json.Marshal(collectMessageData(message))
+	if err != nil {
+		c.Logger().Error(err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to format message data.")
+	}
+	return c.JSONBlob(http.StatusCreated, jsonData)
+}
+
+//gets the :message param, making sure it's in the circle
+func getCircleMessageParam(c echo.Context, circleId CircleId) (*MessageInfo, error) {
+	return lookupCircleMessageParam(c, circleId, false)
+}
+
+func lookupCircleMessageParam(c echo.Context, circleId CircleId, includeDeleted bool) (*MessageInfo, error) {
+	messageId, err := strconv.ParseInt(c.Param("message"), 10, 64)
+	if err != nil {
+		return nil, echo.NewHTTPError(http.StatusUnprocessableEntity, "Message ID must be an integer.")
+	}
+	var message *MessageInfo
+	if includeDeleted {
+		message, err = GetMessageIncludeDeleted(messageId)
+	} else {
+		message, err = GetMessage(messageId)
+	}
+	if err != nil {
+		c.Logger().Error(err)
+		return nil, echo.NewHTTPError(http.StatusInternalServerError, "Failed to get message.")
+	} else if message == nil || message.CircleId != circleId {
+		return nil, echo.NewHTTPError(http.StatusNotFound, "Message not found in this circle.")
+	}
+	return message, nil
+}
+
+func validateMessageBody(body string) error {
+	if len(strings.TrimSpace(body)) < 1 || utf8.RuneCountInString(body) > MESSAGE_BODY_MAX_LENGTH {
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, fmt.Sprintf("Message must be between 1 and %d characters.", MESSAGE_BODY_MAX_LENGTH))
+	}
+	return nil
+}
+
+//POST /api/circle/:circle/messages/:message
+func RouteApiCircleMessageEdit(c echo.Context) error {
+	accountId, circleId, _ := getPermissionContext(c)
+
+	message, err := getCircleMessageParam(c, circleId)
+	if err != nil {
+		return err
+	} else if message.AuthorId != accountId {
+		return echo.NewHTTPError(http.StatusForbidden, "Only the author can edit a message.")
+	}
+	body := c.FormValue("body")
+	if err := validateMessageBody(body); err != nil {
+		return err
+	}
+
+	if err := EditMessage(message, body); err != nil {
+		c.Logger().Error(err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to edit message.")
+	}
+	EventHub.Publish(circleId, EVENT_MESSAGE_EDIT, collectMessageData(message))
+
+	jsonData, err := json.Marshal(collectMessageData(message))
+	if err != nil {
+		c.Logger().Error(err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to format message data.")
+	}
+	return c.JSONBlob(http.StatusOK, jsonData)
+}
+
+//DELETE /api/circle/:circle/messages/:message
+func RouteApiCircleMessageDelete(c echo.Context) error {
+	accountId, circleId, permissions := getPermissionContext(c)
+
+	message, err := getCircleMessageParam(c, circleId)
+	if err != nil {
+		return err
+	}
+	//authors can delete their own messages with either permission
+	if message.AuthorId != accountId || !permissions[PERM_DELETE_OWN_CONTENT.Number] {
+		if err := ensurePermissions(c, accountId, circleId, PERM_DELETE_CONTENT); err != nil {
+			return err
+		}
+	}
+
+	if err := DeleteMessage(message.Id); err != nil {
+		c.Logger().Error(err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to delete message.")
+	}
+	EventHub.Publish(circleId, EVENT_MESSAGE_DELETE, map[string]interface{}{"id": message.Id})
+	return c.NoContent(http.StatusOK)
+}
+
+//GET /api/circle/:circle/messages/:message/revisions
+func RouteApiCircleMessageRevisions(c echo.Context) error {
+	_, circleId, _ := getPermissionContext(c)
+
+	//revisions are kept so deleted messages can still be looked into
+	message, err := lookupCircleMessageParam(c, circleId, true)
+	if err != nil {
+		return err
+	}
+
+	revisions, err := GetMessageRevisions(message.Id)
+	if err != nil {
+		c.Logger().Error(err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get message revisions.")
+	}
+	//each revision was written when the one before it was replaced
+	written := message.Created
+	revisionDatas := make([]map[string]interface{}, len(revisions))
+	for i, revision := range revisions {
+		revisionDatas[i] = map[string]interface{}{
+			"id": revision.Id,
+			"body": revision.Body,
+			"written": written.Format(time.RFC3339),
+			"replaced": revision.Created.Format(time.RFC3339),
+		}
+		written = revision.Created
+	}
+
+	jsonData, err := json.Marshal(map[string]interface{}{
+		"message": collectMessageData(message),
+		"revisions": revisionDatas,
+	})
+	if err != nil {
+		c.Logger().Error(err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to format message revision data.")
+	}
+	return c.JSONBlob(http.StatusOK, jsonData)
+}
+
+//gets what a reaction route is for from the :post or :message param, making sure it's in the circle
+func getReactionTarget(c echo.Context, circleId CircleId) (ReactionTarget, error) {
+	if len(c.Param("post")) > 0 {
+		post, err := getCirclePostParam(c, circleId)
+		if err != nil {
+			return ReactionTarget{}, err
+		}
+		return ReactionTarget{ContentType: COM_TYPE_POST, ContentId: post.Id}, nil
+	}
+	message, err := getCircleMessageParam(c, circleId)
+	if err != nil {
+		return ReactionTarget{}, err
+	}
+	return ReactionTarget{ContentType: COM_TYPE_MESSAGE, ContentId: message.Id}, nil
+}
+
+//parses the emoji or emoji_id value, custom emojis have to be usable in the circle
+func parseReactionEmoji(c echo.Context, circleId CircleId, emojiString string, emojiIdString string) (ReactionEmoji, error) {
+	if len(emojiIdString) > 0 {
+		emojiId, err := strconv.ParseInt(emojiIdString, 10, 64)
+		if err != nil {
+			return ReactionEmoji{}, echo.NewHTTPError(http.StatusUnprocessableEntity, "Emoji ID must be an integer.")
+		}
+		usable, err := IsCustomEmojiUsable(emojiId, circleId)
+		if err != nil {
+			c.Logger().Error(err)
+			return ReactionEmoji{}, echo.NewHTTPError(http.StatusInternalServerError, "Failed to get emoji.")
+		} else if !usable {
+			return ReactionEmoji{}, echo.NewHTTPError(http.StatusNotFound, "Emoji not found in this circle.")
+		}
+		return ReactionEmoji{EmojiId: emojiId}, nil
+	} else if len(emojiString) < 1 {
+		return ReactionEmoji{}, echo.NewHTTPError(http.StatusBadRequest, "Missing value: \"emoji\" or \"emoji_id\"")
+	} else if !IsUnicodeEmoji(emojiString) {
+		return ReactionEmoji{}, echo.NewHTTPError(http.StatusUnprocessableEntity, "Emoji must be a single unicode emoji.")
+	}
+	return ReactionEmoji{Emoji: emojiString}, nil
+}
+
+func collectReactionEventData(target ReactionTarget, accountId AccountId, emoji ReactionEmoji) map[string]interface{} {
+	return map[string]interface{}{
+		"content_type": target.ContentType,
+		"content_id": target.ContentId,
+		"account_id": accountId,
+		"emoji": emoji.Emoji,
+		"emoji_id": emoji.EmojiId,
+	}
+}
+
+//GET /api/circle/:circle/posts/:post/reactions
+//GET /api/circle/:circle/messages/:message/reactions
+func RouteApiReactions(c echo.Context) error {
+	accountId, circleId, _ := getPermissionContext(c)
+
+	target, err := getReactionTarget(c, circleId)
+	if err != nil {
+		return err
+	}
+
+	counts, err := GetReactionCounts(target, accountId)
+	if err != nil {
+		c.Logger().Error(err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get reactions.")
+	}
+	countDatas := make([]map[string]interface{}, len(counts))
+	for i, count := range counts {
+		countDatas[i] = map[string]interface{}{
+			"emoji": count.Emoji,
+			"emoji_id": count.EmojiId,
+			"count": count.Count,
+			"reacted": count.Reacted,
+		}
+	}
+
+	jsonData, err := json.Marshal(countDatas)
+	if err != nil {
+		c.Logger().Error(err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to format reaction data.")
+	}
+	return c.JSONBlob(http.StatusOK, jsonData)
+}
+
+//GET /api/circle/:circle/posts/:post/reactions/accounts?emoji|emoji_id&after&limit
+//GET /api/circle/:circle/messages/:message/reactions/accounts?emoji|emoji_id&after&limit
+func RouteApiReactors(c echo.Context) error {
+	_, circleId, _ := getPermissionContext(c)
+
+	target, err := getReactionTarget(c, circleId)
+	if err != nil {
+		return err
+	}
+	emoji, err := parseReactionEmoji(c, circleId, c.QueryParam("emoji"), c.QueryParam("emoji_id"))
+	if err != nil {
+		return err
+	}
+	var after *int64 = nil
+	if afterString := c.QueryParam("after"); len(afterString) > 0 {
+		afterId, err := strconv.ParseInt(afterString, 10, 64)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusUnprocessableEntity, "After must be a reactor ID.")
+		}
+		after = &afterId
+	}
+	limit, err := parsePageLimit(c, REACTORS_PAGE_SIZE_DEFAULT, REACTORS_PAGE_SIZE_MAX)
+	if err != nil {
+		return err
+	}
+
+	reactors, err := GetReactors(target, emoji, after, limit)
+	if err != nil {
+		c.Logger().Error(err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get reactors.")
+	}
+	reactorDatas := make([]map[string]interface{}, len(reactors))
+	for i, reactor := range reactors {
+		reactorDatas[i] = map[string]interface{}{
+			"id": reactor.Id,
+			"account_id": reactor.AccountId,
+			"username": reactor.Username,
+			"created": reactor.Created.Format(time.RFC3339),
+		}
+	}
+	var next *int64 = nil
+	if len(reactors) == limit {
+		next = &reactors[len(reactors)-1].Id
+	}
+
+	jsonData, err := json.Marshal(map[string]interface{}{
+		"accounts": reactorDatas,
+		"next": next,
+	})
+	if err != nil {
+		c.Logger().Error(err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to format reactor data.")
+	}
+	return c.JSONBlob(http.StatusOK, jsonData)
+}
+
+//POST /api/circle/:circle/posts/:post/reactions
+//POST /api/circle/:circle/messages/:message/reactions
+func RouteApiReactionAdd(c echo.Context) error {
+	accountId, circleId, _ := getPermissionContext(c)
+
+	target, err := getReactionTarget(c, circleId)
+	if err != nil {
+		return err
+	}
+	emoji, err := parseReactionEmoji(c, circleId, c.FormValue("emoji"), c.FormValue("emoji_id"))
+	if err != nil {
+		return err
+	}
+	//starting a reaction and joining one someone else started are separate permissions
+	exists, err := HasReaction(target, emoji)
+	if err != nil {
+		c.Logger().Error(err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get reactions.")
+	}
+	required := PERM_REACT_CONTENT_NEW
+	if exists {
+		required = PERM_REACT_CONTENT_ADD
+	}
+	if err := ensurePermissions(c, accountId, circleId, required); err != nil {
+		return err
+	}
+
+	added, err := AddReaction(target, accountId, emoji)
+	if err != nil {
+		c.Logger().Error(err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to add reaction.")
+	} else if !added {
+		return echo.NewHTTPError(http.StatusConflict, "Already reacted with this emoji.")
+	}
+	EventHub.Publish(circleId, EVENT_REACTION_ADD, collectReactionEventData(target, accountId, emoji))
+	return c.NoContent(http.StatusCreated)
+}
+
+//DELETE /api/circle/:circle/posts/:post/reactions?emoji|emoji_id
+//DELETE /api/circle/:circle/messages/:message/reactions?emoji|emoji_id
+func RouteApiReactionRemove(c echo.Context) error {
+	accountId, circleId, _ := getPermissionContext(c)
+
+	target, err := getReactionTarget(c, circleId)
+	if err != nil {
+		return err
+	}
+	emoji, err := parseReactionEmoji(c, circleId, c.QueryParam("emoji"), c.QueryParam("emoji_id"))
+	if err != nil {
+		return err
+	}
+
+	removed, err := RemoveReaction(target, accountId, emoji)
+	if err != nil {
+		c.Logger().Error(err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to remove reaction.")
+	} else if !removed {
+		return echo.NewHTTPError(http.StatusNotFound, "Not reacted with this emoji.")
+	}
+	EventHub.Publish(circleId, EVENT_REACTION_REMOVE, collectReactionEventData(target, accountId, emoji))
+	return c.NoContent(http.StatusOK)
+}
+
+func collectCustomEmojiData(emoji *CustomEmoji) map[string]interface{} {
+	return map[string]interface{}{
+		"id": emoji.Id,
+		"circle_id": emoji.CircleId,
+		"name": emoji.Name,
+		"image": "/media/" + emoji.Image,
+		"creator_id": emoji.CreatorId,
+		"created": emoji.Created.Format(time.RFC3339),
+	}
+}
+
+//GET /api/circle/:circle/emojis
+func RouteApiCircleEmojis(c echo.Context) error {
+	_, circleId, _ := getPermissionContext(c)
+
+	emojis, err := GetUsableCustomEmojis(circleId)
+	if err != nil {
+		c.Logger().Error(err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get emojis.")
+	}
+	emojiDatas := make([]map[string]interface{}, len(emojis))
+	for i := range emojis {
+		emojiDatas[i] = collectCustomEmojiData(&emojis[i])
+	}
+
+	jsonData, err := json.Marshal(emojiDatas)
+	if err != nil {
+		c.Logger().Error(err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to format emoji data.")
+	}
+	return c.JSONBlob(http.StatusOK, jsonData)
+}
+
+//POST /api/circle/:circle/emojis
+func RouteApiCircleEmojiCreate(c echo.Context) error {
+	accountId, circleId, _ := getPermissionContext(c)
+
+	name := strings.TrimSpace(c.FormValue("name"))
+	if l := len(name); l < 1 || l > EMOJI_NAME_MAX_LENGTH {
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, fmt.Sprintf("Emoji name must be between 1 and %d characters.", EMOJI_NAME_MAX_LENGTH))
+	}
+	imageFile, err := c.FormFile("image")
+	if err != nil {
+		if errors.Is(err, http.ErrMissingFile) {
+			return echo.NewHTTPError(http.StatusBadRequest, "Missing form value: \"image\"")
+		}
+		c.Logger().Error(err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to load emoji image submission info.")
+	}
+	imageExtIndex := strings.LastIndexByte(imageFile.Filename, '.')
+	if imageExtIndex < 0 {
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, "Unknown file type.")
+	}
+	imageExt := strings.ToLower(imageFile.Filename[imageExtIndex+1:])
+	imageType, ok := EMOJI_IMAGE_TYPES[imageExt]
+	if !ok {
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, "Emoji image must be a png, gif, webp or jpg.")
+	}
+	imageSrc, err := imageFile.Open()
+	if err != nil {
+		c.Logger().Error(err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to load emoji image submission.")
+	}
+	defer imageSrc.Close()
+
+	//the content has to match the extension, the file is served back as whatever the extension says
+	head := make([]byte, 512)
+	n, err := io.ReadFull(imageSrc, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		c.Logger().Error(err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to load emoji image submission.")
+	}
+	if http.DetectContentType(head[:n]) != imageType {
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, "Emoji image content doesn't match its file type.")
+	}
+	if _, err := imageSrc.Seek(0, io.SeekStart); err != nil {
+		c.Logger().Error(err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to load emoji image submission.")
+	}
+
+	imageName, err := CreateMediaFile(imageExt, imageSrc)
+	if err != nil {
+		c.Logger().Error(err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to save emoji image submission.")
+	}
+	emoji, err := CreateCustomEmoji(circleId, name, imageName, accountId)
+	if err != nil {
+		if err := os.Remove(filepath.Join(MEDIA_DIR, imageName)); err != nil {
+			c.Logger().Error(err)
+		}
+		if _, ok := err.(*DuplicateEmojiNameError); ok {
+			return echo.NewHTTPError(http.StatusConflict, "An emoji with this name already exists.")
+		}
+		c.Logger().Error(err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to create emoji.")
+	}
+
+	jsonData, err := json.Marshal(collectCustomEmojiData(&emoji))
+	if err != nil {
+		c.Logger().Error(err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to format emoji data.")
+	}
+	return c.JSONBlob(http.StatusCreated, jsonData)
+}
+
+//DELETE /api/circle/:circle/emojis/:emoji
+func RouteApiCircleEmojiDelete(c echo.Context) error {
+	_, circleId, _ := getPermissionContext(c)
+
+	emojiId, err := strconv.ParseInt(c.Param("emoji"), 10, 64)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, "Emoji ID must be an integer.")
+	}
+	emoji, err := GetCustomEmoji(emojiId)
+	if err != nil {
+		c.Logger().Error(err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get emoji.")
+	} else if emoji == nil || emoji.CircleId != circleId {
+		return echo.NewHTTPError(http.StatusNotFound, "Emoji not found in this circle.")
+	}
+
+	if err := DeleteCustomEmoji(emoji.Id); err != nil {
+		c.Logger().Error(err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to delete emoji.")
+	}
+	if err := os.Remove(filepath.Join(MEDIA_DIR, emoji.Image)); err != nil {
+		c.Logger().Error(err)
+	}
+	return c.NoContent(http.StatusOK)
+}
+
+//writes the event in the text/event-stream format
+func writeEvent(w io.Writer, event *CircleEvent) error {
+	data, err := json.Marshal(map[string]interface{}{
+		"circle_id": event.CircleId,
+		"data": event.Data,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.Id, event.Type, data)
+	return err
+}
+
+//GET /api/events?circles
+//streams events from the circles as server-sent events. a client that falls behind gets an overflow event
+//and is disconnected, it should catch up through the regular endpoints before subscribing again
+func RouteApiEvents(c echo.Context) error {
+	_, accountId, err := getContextIds(c)
+	if err != nil {
+		return err
+	}
+
+	circles, err := parseIdList(c.QueryParam("circles"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, "Circle IDs must be integers.")
+	} else if len(circles) < 1 {
+		return echo.NewHTTPError(http.StatusBadRequest, "Missing query value: \"circles\"")
+	} else if len(circles) > EVENT_SUBSCRIPTION_MAX {
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, fmt.Sprintf("Cannot subscribe to more than %d circles at once.", EVENT_SUBSCRIPTION_MAX))
+	}
+	for _, circleId := range circles {
+		if err := ensureCanViewCircle(c, accountId, circleId); err != nil {
+			return err
+		}
+	}
+
+	subscriber := EventHub.Subscribe(accountId, circles)
+	defer EventHub.Unsubscribe(subscriber)
+
+	res := c.Response()
+	res.Header().Set(echo.HeaderContentType, "text/event-stream")
+	res.Header().Set(echo.HeaderCacheControl, "no-cache")
+	res.WriteHeader(http.StatusOK)
+	res.Flush()
+
+	keepalive := time.NewTicker(EVENT_KEEPALIVE_INTERVAL)
+	defer keepalive.Stop()
+	done := c.Request().Context().Done()
+	for {
+		select {
+		case <-done:
+			return nil
+		case <-subscriber.Overflowed():
+			_, err := io.WriteString(res, "event: overflow\ndata: {}\n\n")
+			res.Flush()
+			return err
+		case event := <-subscriber.Events:
+			if err := writeEvent(res, &event); err != nil {
+				return err
+			}
+			res.Flush()
+		case <-keepalive.C:
+			if _, err := io.WriteString(res, ": keepalive\n\n"); err != nil {
+				return err
+			}
+			res.Flush()
+		}
+	}
+}
+
+func BindApiRoutes() {
+	ApiGroup.POST("/circle", RouteApiCircleCreate)
+	ApiGroup.DELETE("/circle/:circle", RouteApiCircleDelete, RequirePermission())
+	ApiGroup.POST("/circle/:circle/restore", RouteApiCircleRestore, RequirePermission())
+	ApiGroup.GET("/circle/:circle/trash", RouteApiCircleTrash, RequirePermission(PERM_DELETE_SUBCIRCLE))
+	ApiGroup.POST("/circle/:circle/name", RouteApiCircleRename, RequirePermission(PERM_CHANGE_CIRCLE_NAME))
+	ApiGroup.GET("/circle/:circle/parent", RouteApiCircleParent, RequirePermission(PERM_VIEW_CIRCLE))
+	ApiGroup.POST("/circle/:circle/parent", RouteApiCircleMove, RequirePermission())
+	ApiGroup.GET("/circle/:circle/parents", RouteApiCircleParents, RequirePermission(PERM_VIEW_CIRCLE))
+	ApiGroup.GET("/circle/:circle/children", RouteApiCircleChildren, RequirePermission(PERM_VIEW_CIRCLE))
+	ApiGroup.POST("/circle/:circle/children", RouteApiCircleCreateChild, RequirePermission(PERM_CREATE_SUBCIRCLE))
+	ApiGroup.GET("/circle/:circle/hierarchy", RouteApiCircleHierarchy, RequirePermission(PERM_VIEW_CIRCLE))
+	ApiGroup.GET("/circle/:circle/inheritance", RouteApiCircleInheritance, RequirePermission(PERM_VIEW_CIRCLE))
+	//inherited roles decide permissions, so opting out is treated as editing permissions
+	ApiGroup.POST("/circle/:circle/inheritance", RouteApiCircleInheritanceEdit, RequirePermission(PERM_EDIT_ROLE_PERMISSIONS))
+	ApiGroup.POST("/circle/:circle/default_subcircle/com_type", RouteApiCircleDefaultComTypeEdit, RequirePermission(PERM_EDIT_DEFAULT_SUBCIRCLE_COM_TYPE))
+	ApiGroup.GET("/circle/:circle/default_subcircle/permissions", RouteApiCircleDefaultPermissions, RequirePermission(PERM_VIEW_CIRCLE))
+	ApiGroup.POST("/circle/:circle/default_subcircle/permissions", RouteApiCircleDefaultPermissionsEdit, RequirePermission(PERM_EDIT_DEFAULT_SUBCIRCLE_PERMISSIONS))
+	ApiGroup.GET("/circle/:circle/members", RouteApiCircleMembers, RequirePermission(PERM_VIEW_CIRCLE))
+	ApiGroup.DELETE("/circle/:circle/members/:account", RouteApiCircleMemberRemove, RequirePermission(PERM_REMOVE_CIRCLE_MEMBERS))
+	//circles that can't be viewed by non-members need an invite
+	ApiGroup.POST("/circle/:circle/join", RouteApiCircleJoin, RequirePermission(PERM_VIEW_CIRCLE))
+	ApiGroup.POST("/circle/:circle/leave", RouteApiCircleLeave, RequirePermission())
+	ApiGroup.GET("/circle/:circle/invites", RouteApiCircleInvites, RequirePermission(PERM_INVITE_CIRCLE_MEMBERS))
+	ApiGroup.POST("/circle/:circle/invites", RouteApiCircleInviteCreate, RequirePermission(PERM_INVITE_CIRCLE_MEMBERS))
+	ApiGroup.GET("/circle/:circle/bans", RouteApiCircleBans, RequirePermission(PERM_BAN_CIRCLE_MEMBERS))
+	ApiGroup.POST("/circle/:circle/bans", RouteApiCircleBan, RequirePermission(PERM_BAN_CIRCLE_MEMBERS))
+	ApiGroup.DELETE("/circle/:circle/bans/:account", RouteApiCircleUnban, RequirePermission(PERM_BAN_CIRCLE_MEMBERS))
+	ApiGroup.GET("/circle/:circle/mutes", RouteApiCircleMutes, RequirePermission(PERM_MUTE_CIRCLE_MEMBERS))
+	ApiGroup.POST("/circle/:circle/mutes", RouteApiCircleMute, RequirePermission(PERM_MUTE_CIRCLE_MEMBERS))
+	ApiGroup.DELETE("/circle/:circle/mutes/:account", RouteApiCircleUnmute, RequirePermission(PERM_MUTE_CIRCLE_MEMBERS))
+	ApiGroup.GET("/circle/:circle/roles", RouteApiCircleRoles, RequirePermission(PERM_VIEW_CIRCLE))
+	ApiGroup.POST("/circle/:circle/roles", RouteApiCircleRoleCreate, RequirePermission(PERM_ADD_ROLE))
+	ApiGroup.GET("/circle/:circle/roles/all", RouteApiCircleRolesAll, RequirePermission(PERM_VIEW_CIRCLE))
+	//order decides which role's permissions win, so it's treated as editing permissions
+	ApiGroup.POST("/circle/:circle/roles/order", RouteApiCircleRolesReorder, RequirePermission(PERM_EDIT_ROLE_PERMISSIONS))
+	ApiGroup.GET("/circle/:circle/roles/permissions", RouteApiCircleRolesPermissions, RequirePermission(PERM_VIEW_CIRCLE))
+	ApiGroup.POST("/circle/:circle/roles/:role", RouteApiCircleRoleEdit, RequirePermission())
+	ApiGroup.DELETE("/circle/:circle/roles/:role", RouteApiCircleRoleDelete, RequirePermission(PERM_DELETE_ROLE))
+	ApiGroup.GET("/circle/:circle/roles/:role/members", RouteApiCircleRoleMembers, RequirePermission(PERM_VIEW_CIRCLE))
+	ApiGroup.POST("/circle/:circle/roles/:role/members", RouteApiCircleRoleMembersAdd, RequirePermission())
+	ApiGroup.DELETE("/circle/:circle/roles/:role/members/:account", RouteApiCircleRoleMemberRemove, RequirePermission())
+	ApiGroup.GET("/circle/:circle/members/:account/permissions", RouteApiCircleMemberPermissions, RequirePermission(PERM_EDIT_ROLE_PERMISSIONS))
+	ApiGroup.POST("/circle/:circle/members/:account/permissions", RouteApiCircleMemberPermissionsEdit, RequirePermission(PERM_EDIT_ROLE_PERMISSIONS))
+	ApiGroup.GET("/circle/:circle/permissions/explain", RouteApiCirclePermissionsExplain, RequirePermission(PERM_VIEW_CIRCLE))
+	ApiGroup.GET("/circle/:circle/permissions/simulate", RouteApiCirclePermissionsSimulate, RequirePermission(PERM_EDIT_ROLE_PERMISSIONS))
+	ApiGroup.GET("/circle/:circle/permissions/:role", RouteApiCircleRolePermissions, RequirePermission(PERM_VIEW_CIRCLE))
+	ApiGroup.POST("/circle/:circle/permissions/:role", RouteApiCircleRolePermissionsEdit, RequirePermission(PERM_EDIT_ROLE_PERMISSIONS))
+	ApiGroup.GET("/circle/:circle/audit", RouteApiCircleAudit, RequirePermission(PERM_EDIT_ROLE_PERMISSIONS))
+	ApiGroup.GET("/circle/:circle/posts", RouteApiCirclePosts, RequirePermission(PERM_VIEW_CIRCLE))
+	ApiGroup.POST("/circle/:circle/posts", RouteApiCirclePostCreate, RequirePermission(PERM_VIEW_CIRCLE, PERM_SEND_CONTENT))
+	ApiGroup.GET("/circle/:circle/posts/:post", RouteApiCirclePost, RequirePermission(PERM_VIEW_CIRCLE))
+	ApiGroup.POST("/circle/:circle/posts/:post", RouteApiCirclePostEdit, RequirePermission(PERM_VIEW_CIRCLE, PERM_EDIT_OWN_CONTENT))
+	ApiGroup.DELETE("/circle/:circle/posts/:post", RouteApiCirclePostDelete, RequirePermission(PERM_VIEW_CIRCLE))
+	//revisions are for moderators settling disputes over what was said
+	ApiGroup.GET("/circle/:circle/posts/:post/revisions", RouteApiCirclePostRevisions, RequirePermission(PERM_VIEW_CIRCLE, PERM_DELETE_CONTENT))
+	ApiGroup.GET("/circle/:circle/messages", RouteApiCircleMessages, RequirePermission(PERM_VIEW_CIRCLE))
+	ApiGroup.POST("/circle/:circle/messages", RouteApiCircleMessageSend, RequirePermission(PERM_VIEW_CIRCLE, PERM_SEND_CONTENT))
+	ApiGroup.POST("/circle/:circle/messages/:message", RouteApiCircleMessageEdit, RequirePermission(PERM_VIEW_CIRCLE, PERM_EDIT_OWN_CONTENT))
+	ApiGroup.DELETE("/circle/:circle/messages/:message", RouteApiCircleMessageDelete, RequirePermission(PERM_VIEW_CIRCLE))
+	ApiGroup.GET("/circle/:circle/messages/:message/revisions", RouteApiCircleMessageRevisions, RequirePermission(PERM_VIEW_CIRCLE, PERM_DELETE_CONTENT))
+	ApiGroup.GET("/circle/:circle/posts/:post/reactions", RouteApiReactions, RequirePermission(PERM_VIEW_CIRCLE))
+	ApiGroup.GET("/circle/:circle/posts/:post/reactions/accounts", RouteApiReactors, RequirePermission(PERM_VIEW_CIRCLE))
+	ApiGroup.POST("/circle/:circle/posts/:post/reactions", RouteApiReactionAdd, RequirePermission(PERM_VIEW_CIRCLE))
+	ApiGroup.DELETE("/circle/:circle/posts/:post/reactions", RouteApiReactionRemove, RequirePermission(PERM_VIEW_CIRCLE))
+	ApiGroup.GET("/circle/:circle/messages/:message/reactions", RouteApiReactions, RequirePermission(PERM_VIEW_CIRCLE))
+	ApiGroup.GET("/circle/:circle/messages/:message/reactions/accounts", RouteApiReactors, RequirePermission(PERM_VIEW_CIRCLE))
+	ApiGroup.POST("/circle/:circle/messages/:message/reactions", RouteApiReactionAdd, RequirePermission(PERM_VIEW_CIRCLE))
+	ApiGroup.DELETE("/circle/:circle/messages/:message/reactions", RouteApiReactionRemove, RequirePermission(PERM_VIEW_CIRCLE))
+	ApiGroup.GET("/circle/:circle/emojis", RouteApiCircleEmojis, RequirePermission(PERM_VIEW_CIRCLE))
+	ApiGroup.POST("/circle/:circle/emojis", RouteApiCircleEmojiCreate, RequirePermission(PERM_MANAGE_EMOJIS))
+	ApiGroup.DELETE("/circle/:circle/emojis/:emoji", RouteApiCircleEmojiDelete, RequirePermission(PERM_MANAGE_EMOJIS))
+	ApiGroup.GET("/permissions", RouteApiPermissions)
+	ApiGroup.GET("/events", RouteApiEvents)
+	ApiGroup.GET("/invite/:code", RouteApiInvite)
+	ApiGroup.POST("/invite/:code/join", RouteApiInviteJoin)
+	ApiGroup.DELETE("/invite/:code", RouteApiInviteRevoke)
+}
\ No newline at end of file
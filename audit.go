@@ -0,0 +1,191 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+	"time"
+)
+
+const (
+	AUDIT_ROLE_CREATE string = "role_create"
+	AUDIT_ROLE_UPDATE string = "role_update"
+	AUDIT_ROLE_DELETE string = "role_delete"
+	AUDIT_ROLE_REORDER string = "role_reorder"
+	AUDIT_ROLE_PERMISSIONS_UPDATE string = "role_permissions_update"
+	AUDIT_ROLE_MEMBER_ADD string = "role_member_add"
+	AUDIT_ROLE_MEMBER_REMOVE string = "role_member_remove"
+	AUDIT_MEMBER_PERMISSIONS_UPDATE string = "member_permissions_update"
+	AUDIT_MEMBER_JOIN string = "member_join"
+	AUDIT_MEMBER_LEAVE string = "member_leave"
+	AUDIT_MEMBER_REMOVE string = "member_remove"
+	AUDIT_MEMBER_BAN string = "member_ban"
+	AUDIT_MEMBER_UNBAN string = "member_unban"
+	AUDIT_MEMBER_MUTE string = "member_mute"
+	AUDIT_MEMBER_UNMUTE string = "member_unmute"
+	AUDIT_CIRCLE_INHERITANCE_UPDATE string = "circle_inheritance_update"
+
+	AUDIT_PAGE_SIZE_DEFAULT int = 50
+	AUDIT_PAGE_SIZE_MAX int = 200
+)
+
+//what the audit log is written through, so entries can be part of the transaction making the change
+type sqlExecer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+type AuditEntry struct {
+	Id int64
+	CircleId CircleId
+	ActorId *AccountId //nil for changes the server made on its own
+	Action string
+	TargetId *int64 //what the action was done to, depends on the action
+	Before json.RawMessage
+	After json.RawMessage
+	Created time.Time
+}
+
+//the log is append-only, entries are only ever removed by PurgeExpiredAuditLog
+func writeAudit(execer sqlExecer, circle CircleId, actor *AccountId, action string, target *int64, before interface{}, after interface{}) error {
+	var beforeValue, afterValue *string
+	for _, pair := range []struct{
+		value interface{}
+		dest **string
+	}{{before, &beforeValue}, {after, &afterValue}} {
+		if pair.value == nil {
+			continue
+		}
+		encoded, err := json.Marshal(pair.value)
+		if err != nil {
+			return err
+		}
+		encodedString := string(encoded)
+		*pair.dest = &encodedString
+	}
+	_, err := execer.Exec(
+		"INSERT INTO audit_log (circle_id, actor_id, action, target_id, before_value, after_value, created) VALUES(?, ?, ?, ?, ?, ?, ?)",
+		circle, actor, action, target, beforeValue, afterValue, time.Now(),
+	)
+	return err
+}
+
+func auditRole(role *RoleInfo) map[string]interface{} {
+	return map[string]interface{}{
+		"name": role.Name,
+		"order": role.Order,
+		"color": hex.EncodeToString(role.Color),
+	}
+}
+
+//the values of the changed permissions before and after the change, by name. nil means the permission wasn't set
+func auditPermissionChanges(current PermissionsList, changes map[PermissionNumber]*bool) (before map[string]*bool, after map[string]*bool) {
+	before = make(map[string]*bool, len(changes))
+	after = make(map[string]*bool, len(changes))
+	for permNum, granted := range changes {
+		name := PermissionRegistry.Get(permNum).Name
+		if previous, ok := current[permNum]; ok {
+			before[name] = &previous
+		} else {
+			before[name] = nil
+		}
+		after[name] = granted
+	}
+	return before, after
+}
+
+//all fields are optional, Before is an entry id to page backwards from
+type AuditFilter struct {
+	ActorId *AccountId
+	TargetId *int64
+	Actions []string
+	Before *int64
+}
+
+//gets entries newest first
+func GetAuditLog(circle CircleId, filter AuditFilter, limit int) ([]AuditEntry, error) {
+	b := strings.Builder{}
+	b.WriteString("SELECT id, actor_id, action, target_id, before_value, after_value, created FROM audit_log WHERE circle_id=?")
+	args := []interface{}{circle}
+	if filter.ActorId != nil {
+		b.WriteString(" AND actor_id=?")
+		args = append(args, *filter.ActorId)
+	}
+	if filter.TargetId != nil {
+		b.WriteString(" AND target_id=?")
+		args = append(args, *filter.TargetId)
+	}
+	if len(filter.Actions) > 0 {
+		b.WriteString(" AND action IN (?")
+		b.WriteString(strings.Repeat(", ?", len(filter.Actions)-1))
+		b.WriteString(")")
+		for _, action := range filter.Actions {
+			args = append(args, action)
+		}
+	}
+	if filter.Before != nil {
+		b.WriteString(" AND id<?")
+		args = append(args, *filter.Before)
+	}
+	b.WriteString(" ORDER BY id DESC LIMIT ?")
+	args = append(args, limit)
+
+	rows, err := MainDB.Query(b.String(), args...)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := make([]AuditEntry, 0)
+	for rows.Next() {
+		var (
+			entry = AuditEntry{CircleId: circle}
+			before, after *string
+		)
+		if err := rows.Scan(&entry.Id, &entry.ActorId, &entry.Action, &entry.TargetId, &before, &after, &entry.Created); err != nil {
+			return nil, err
+		}
+		if before != nil {
+			entry.Before = json.RawMessage(*before)
+		}
+		if after != nil {
+			entry.After = json.RawMessage(*after)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+//removes entries older than AuditLogRetention, returning how many were removed
+func PurgeExpiredAuditLog() (int64, error) {
+	if AuditLogRetention <= 0 {
+		return 0, nil
+	}
+	r, err := MainDB.Exec("DELETE FROM audit_log WHERE created<?", time.Now().Add(-AuditLogRetention))
+	if err != nil {
+		return 0, err
+	}
+	return r.RowsAffected()
+}
+
+//runs PurgeExpiredAuditLog every AuditLogSweepInterval
+func StartAuditLogSweeper() {
+	if AuditLogSweepInterval <= 0 || AuditLogRetention <= 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(AuditLogSweepInterval)
+		defer ticker.Stop()
+		for range ticker.C {
+			purged, err := PurgeExpiredAuditLog()
+			if err != nil {
+				App.Logger.Error(err)
+			} else if purged > 0 {
+				App.Logger.Infof("Purged %d audit log entries.", purged)
+			}
+		}
+	}()
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+type permissionCacheKey struct {
+	account AccountId
+	circle CircleId
+}
+
+type permissionCacheEntry struct {
+	resolved ResolvedPermissions
+	expires time.Time
+}
+
+//caches resolved account permissions, anything that can change them should call Invalidate after it's done.
+//functions using transactions defer it before the transaction's own defer so it runs after the commit
+type permissionCache struct {
+	lock sync.RWMutex
+	entries map[permissionCacheKey]permissionCacheEntry
+	//bumped on every invalidation so results worked out before one aren't stored after it
+	generation uint64
+}
+
+var PermissionCache = &permissionCache{entries: make(map[permissionCacheKey]permissionCacheEntry)}
+
+//gets a copy of the cached permissions so callers are free to change them
+func (cache *permissionCache) Get(account AccountId, circle CircleId) (ResolvedPermissions, bool) {
+	cache.lock.RLock()
+	entry, ok := cache.entries[permissionCacheKey{account, circle}]
+	cache.lock.RUnlock()
+	if !ok {
+		return nil, false
+	} else if now := time.Now(); !now.Before(entry.expires) {
+		cache.lock.Lock()
+		//it could have been replaced while the lock was let go
+		if entry, ok := cache.entries[permissionCacheKey{account, circle}]; ok && !now.Before(entry.expires) {
+			delete(cache.entries, permissionCacheKey{account, circle})
+		}
+		cache.lock.Unlock()
+		return nil, false
+	}
+	return copyResolvedPermissions(entry.resolved), true
+}
+
+//gets the generation to pass to Put, should be read before resolving
+func (cache *permissionCache) Generation() uint64 {
+	cache.lock.RLock()
+	defer cache.lock.RUnlock()
+	return cache.generation
+}
+
+//stores the permissions unless the cache was invalidated since the generation was read,
+//until is when the result stops being valid on its own (e.g. a mute running out)
+func (cache *permissionCache) Put(account AccountId, circle CircleId, resolved ResolvedPermissions, until *time.Time, generation uint64) {
+	if PermissionCacheTTL <= 0 {
+		return
+	}
+	expires := time.Now().Add(PermissionCacheTTL)
+	if until != nil && until.Before(expires) {
+		expires = *until
+	}
+
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+	if generation != cache.generation {
+		return
+	}
+	cache.entries[permissionCacheKey{account, circle}] = permissionCacheEntry{
+		resolved: copyResolvedPermissions(resolved),
+		expires: expires,
+	}
+}
+
+//drops expired entries that nothing has asked for since they ran out, returns how many were dropped
+func (cache *permissionCache) Sweep() int {
+	now := time.Now()
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+	swept := 0
+	for key, entry := range cache.entries {
+		if !now.Before(entry.expires) {
+			delete(cache.entries, key)
+			swept++
+		}
+	}
+	return swept
+}
+
+//drops everything, permissions depend on the whole circle tree so there's no narrower way to do it
+func (cache *permissionCache) Invalidate() {
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+	cache.generation++
+	cache.entries = make(map[permissionCacheKey]permissionCacheEntry)
+}
+
+func InvalidatePermissionCache() {
+	PermissionCache.Invalidate()
+}
+
+func copyResolvedPermissions(resolved ResolvedPermissions) ResolvedPermissions {
+	if resolved == nil {
+		return nil
+	}
+	c := make(ResolvedPermissions, len(resolved))
+	for k, v := range resolved {
+		c[k] = v
+	}
+	return c
+}
@@ -2,7 +2,6 @@ package main
 
 import (
 	"database/sql"
-	"encoding/binary"
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
@@ -18,6 +17,9 @@ type CommunicationType = int8
 type AccountId = int64
 type MemberId = int64
 type RoleId = int64
+type PostId = int64
+type MessageId = int64
+type EmojiId = int64
 type PermissionId = int64
 type PermissionNumber = int64
 
@@ -26,8 +28,23 @@ const (
 	COM_TYPE_MESSAGE CommunicationType = 1
 
 	ROLE_NAME_EVERYONE string = "::everyone"
+	ROLE_ORDER_LOWEST int = (1<<31)-1
+	//ranks above every role, given to circle owners
+	ROLE_ORDER_OWNER int = -(1<<31)
+
+	PERMISSION_REASON_ROLE string = "role"
+	PERMISSION_REASON_MEMBER string = "member"
+	PERMISSION_REASON_MUTED string = "muted"
+	PERMISSION_REASON_OWNER string = "owner"
+	PERMISSION_REASON_ADMINISTRATOR string = "administrator"
 )
 
+//selects the circle given as the first argument and all of its parents as rec(id, parent_id, depth), depth 0 being the circle
+const CIRCLE_ANCESTORS_CTE string = `WITH RECURSIVE rec AS (
+	SELECT id, parent_id, 0 AS depth FROM circles WHERE id=?
+	UNION ALL SELECT c.id, c.parent_id, (r.depth+1) FROM circles c JOIN rec r ON c.id = r.parent_id
+)`
+
 type DuplicateCircleNameError struct {
 	message string
 }
@@ -88,31 +105,23 @@ var (
 	PERM_BAN_CIRCLE_MEMBERS = Permission{Name: "ban_circle_members", DisplayName: "Ban Circle Members", Number: 33}
 	PERM_MUTE_CIRCLE_MEMBERS = Permission{Name: "mute_circle_members", DisplayName: "Mute Circle Members", Number: 34}
     PERM_MENTION_EVERYONE = Permission{Name: "mention_everyone", DisplayName: "Mention @everyone", Number: 35}
+	PERM_ADMINISTRATOR = Permission{Name: "administrator", DisplayName: "Administrator", Number: 36}
+	PERM_MANAGE_EMOJIS = Permission{Name: "manage_emojis", DisplayName: "Manage Custom Emojis", Number: 37}
 
 	PERMS_MANAGE_SUBCIRCLE = []Permission{PERM_CREATE_SUBCIRCLE, PERM_DELETE_SUBCIRCLE}
 	PERMS_ALLOW_MARKDOWN = []Permission{PERM_ALLOW_MD_HEADERS, PERM_ALLOW_MD_LINKS, PERM_ALLOW_MD_LISTS, PERM_ALLOW_MD_CODE, PERM_ALLOW_MD_CODE_BLOCK, PERM_ALLOW_MD_BOLD, PERM_ALLOW_MD_ITALIC, PERM_ALLOW_MD_UNDERSCORE, PERM_ALLOW_MD_STRIKE, PERM_ALLOW_MD_SPOILER}
 	PERMS_MANAGE_ROLES = []Permission{PERM_ADD_ROLE, PERM_DELETE_ROLE, PERM_EDIT_ROLE_PERMISSIONS, PERM_EDIT_ROLE_NAME, PERM_EDIT_ROLE_COLOR, PERM_EDIT_ROLE_MEMBERS}
 	PERMS_MANAGE_MEMBERS = []Permission{PERM_REMOVE_CIRCLE_MEMBERS, PERM_BAN_CIRCLE_MEMBERS, PERM_MUTE_CIRCLE_MEMBERS}
+	PERMS_MUTED = []Permission{PERM_SEND_CONTENT, PERM_REACT_CONTENT_NEW, PERM_REACT_CONTENT_ADD}
 	PERMS_ALL = []Permission{
 		PERM_VIEW_CIRCLE, PERM_CHANGE_CIRCLE_NAME, PERM_CREATE_SUBCIRCLE, PERM_DELETE_SUBCIRCLE, PERM_ALLOW_MD_HEADERS, PERM_ALLOW_MD_LINKS, PERM_ALLOW_MD_LISTS,
 		PERM_ALLOW_MD_CODE, PERM_ALLOW_MD_CODE_BLOCK, PERM_ALLOW_MD_BOLD, PERM_ALLOW_MD_ITALIC, PERM_ALLOW_MD_UNDERSCORE, PERM_ALLOW_MD_STRIKE, PERM_ALLOW_MD_SPOILER,
 		PERM_SEND_CONTENT, PERM_DELETE_CONTENT, PERM_DELETE_OWN_CONTENT, PERM_EDIT_OWN_CONTENT, PERM_REACT_CONTENT_NEW, PERM_REACT_CONTENT_ADD, PERM_SEND_ATTACHMENTS,
 		PERM_SEND_EMBEDS, PERM_EDIT_DEFAULT_SUBCIRCLE_COM_TYPE, PERM_EDIT_DEFAULT_SUBCIRCLE_PERMISSIONS, PERM_ADD_ROLE, PERM_DELETE_ROLE, PERM_EDIT_ROLE_PERMISSIONS,
 		PERM_EDIT_ROLE_NAME, PERM_EDIT_ROLE_COLOR, PERM_EDIT_ROLE_MEMBERS, PERM_INVITE_CIRCLE_MEMBERS, PERM_REMOVE_CIRCLE_MEMBERS, PERM_BAN_CIRCLE_MEMBERS, PERM_MUTE_CIRCLE_MEMBERS,
-        PERM_MENTION_EVERYONE,
+        PERM_MENTION_EVERYONE, PERM_ADMINISTRATOR, PERM_MANAGE_EMOJIS,
 	}
 
-	PERMS_NAME_MAP = func()map[string]Permission {
-		rtv := make(map[string]Permission, len(PERMS_ALL))
-		for _, p := range PERMS_ALL {
-			if p.Number == 0 {
-				panic(fmt.Errorf("unassigned permission number: %s", p.Name))
-			}
-			rtv[p.Name] = p
-		}
-		return rtv
-	}()
-
 	DEFAULT_ROLE_COLOR = []byte{0x7f, 0x7f, 0x7f}
 )
 
@@ -143,76 +152,44 @@ type RoleInfo struct {
 	Color []uint8
 }
 
-func PermissionsFromBytes(data []byte) PermissionsList {
-	if len(data) < 1 {
-		return nil
-	}
-    length := binary.BigEndian.Uint64(data)
-    if length == 0 {
-        return nil
-    }
-    list := make(PermissionsList, length)
-
-    grantBitsCount := (length + (8 - 1)) / 8
-    grantBitsIndex := 0
-    cursor := 8 + grantBitsCount
-
-    for i := uint64(0); i < length; i++ {
-        permissionNumber := int64(binary.BigEndian.Uint64(data[cursor:]))
-        cursor += 8
-        list[permissionNumber] = uint8(data[8 + grantBitsIndex / 8]) & uint8(1 << (grantBitsIndex % 8)) != 0
-        grantBitsIndex++
-    }
-
-    return list
-}
-
-func PermissionsToBytes(list PermissionsList) []byte {
-    length := len(list)
-    if length == 0 {
-        return []byte{0,0,0,0,0,0,0,0}
-    }
-    grantBitsCount := (length + (8 - 1)) / 8
-
-    b := make([]byte, 8 + grantBitsCount + length * 8)
-    binary.BigEndian.PutUint64(b, uint64(length))
-    grantByte := uint8(0)
-    grantBitsIndex := 0
-    cursor := 8 + grantBitsCount
-
-    for permissionNumber, granted := range list {
-        binary.BigEndian.PutUint64(b[cursor:], uint64(permissionNumber))
-        if granted {
-            grantByte |= uint8(1 << (grantBitsIndex % 8))
-        }
-        if grantBitsIndex % 8 == 7 { // == 7 because index is pointing to the end of this byte
-            b[8 + grantBitsIndex / 8] = grantByte
-            grantByte = 0
-        }
-        cursor += 8
-        grantBitsIndex++
-    }
-    if grantBitsIndex % 8 != 0 { //if byte wasn't already added
-        b[8 + (grantBitsIndex - 1) / 8] = grantByte // - 1 because index is pointing at a non-existent next byte
-    }
-
-    return b
+func GetCircleInfo(id CircleId) (*CircleInfo, error)  {
+	return getCircleInfo(id, false)
 }
 
-func GetCircleInfo(id CircleId) (*CircleInfo, error)  {
+//same as GetCircleInfo, but will also find circles that are in the trash
+func GetCircleInfoIncludeDeleted(id CircleId) (*CircleInfo, error) {
+	return getCircleInfo(id, true)
+}
+
+func getCircleInfo(id CircleId, includeDeleted bool) (*CircleInfo, error) {
 	info := &CircleInfo{Id: id}
 	var rawDefaultSubcirclePermissions []byte
-	row := MainDB.QueryRow(`SELECT parent_id, owner_id, name, created, com_type, default_subcircle_com_type, default_subcircle_permissions FROM circles WHERE id=?`, id)
+	queryString := `SELECT parent_id, owner_id, name, created, com_type, default_subcircle_com_type, default_subcircle_permissions FROM circles WHERE id=?`
+	if !includeDeleted {
+		queryString += " AND deleted_id IS NULL"
+	}
+	row := MainDB.QueryRow(queryString, id)
 	if err := row.Scan(&info.ParentId, &info.OwnerId, &info.Name, &info.Created, &info.ComType, &info.DefaultSubcircleComType, &rawDefaultSubcirclePermissions); err != nil {
 		if err == sql.ErrNoRows {
 			return nil, nil
 		}
 		return nil, err
 	}
-	info.DefaultSubcirclePermissions = PermissionsFromBytes(rawDefaultSubcirclePermissions)
+	info.DefaultSubcirclePermissions = readDefaultSubcirclePermissions(id, rawDefaultSubcirclePermissions)
 	return info, nil
 }
 
+//a corrupt default_subcircle_permissions value is logged and read as having no defaults,
+//so one bad value can't break every request for the circle
+func readDefaultSubcirclePermissions(circle CircleId, raw []byte) PermissionsList {
+	permissions, err := PermissionsFromBytes(raw)
+	if err != nil {
+		App.Logger.Errorf("Unreadable default subcircle permissions for circle %d: %v", circle, err)
+		return PermissionsList{}
+	}
+	return permissions
+}
+
 func idSetString(ids []int64) string {
 	roleIdStrings := make([]string, len(ids))
 	idSetSize := 2
@@ -261,116 +238,210 @@ func GetRoleOrder(roles []RoleId) ([]RoleId, string, error) {
 	return orderedList, roleIdSet, nil
 }
 
-func GetSomePermissions(id CircleId, roles []RoleId, permissions []PermissionNumber) (PermissionsList, error) {
+//where the value of a permission came from
+type PermissionSource struct {
+	Granted bool
+	Reason string
+	CircleId CircleId
+	RoleId RoleId
+	RoleName string
+	RoleOrder int
+	Inherited bool //decided in a parent circle rather than the circle being checked
+}
+
+type ResolvedPermissions = map[PermissionNumber]PermissionSource
+
+func (source *PermissionSource) setRole(granted bool, circle CircleId, role RoleId, roleName string, roleOrder int, inherited bool) {
+	source.Granted = granted
+	source.Reason = PERMISSION_REASON_ROLE
+	source.CircleId = circle
+	source.RoleId = role
+	source.RoleName = roleName
+	source.RoleOrder = roleOrder
+	source.Inherited = inherited
+}
+
+func FlattenPermissions(resolved ResolvedPermissions) PermissionsList {
+	if resolved == nil {
+		return nil
+	}
+	permList := make(PermissionsList, len(resolved))
+	for permissionNumber, source := range resolved {
+		permList[permissionNumber] = source.Granted
+	}
+	return permList
+}
+
+//finds the role that decides each permission by RoleRank, checking the circle and then each parent from nearest
+//to furthest. within a circle the highest ranked role wins. permissions can be nil to resolve all of them
+func ResolvePermissions(id CircleId, roles []RoleId, permissions []PermissionNumber) (ResolvedPermissions, error) {
 	roleIdSet := idSetString(roles)
-	permissionIdSet := idSetString(permissions)
-	parents, err := GetAllCircleParents(id)
-	if err != nil {
-		return nil, err
+	if len(roleIdSet) < 1 {
+		return ResolvedPermissions{}, nil
 	}
 
 	const (
-		queryStart string = "SELECT role_permissions.permission_number, role_permissions.granted FROM role_permissions INNER JOIN roles ON role_permissions.role_id=roles.id WHERE role_permissions.circle_id=? AND role_permissions.role_id IN "
-		permissionsSetPart string = " AND role_permissions.permission_number IN "
-		queryEnd string = " ORDER BY roles.priority_order ASC"
+		queryStart string = CIRCLE_ANCESTORS_CTE + ` SELECT rp.permission_number, rp.granted, rec.id, rec.depth, roles.id, roles.name, roles.priority_order FROM rec
+			INNER JOIN role_permissions rp ON rp.circle_id=rec.id INNER JOIN roles ON rp.role_id=roles.id WHERE rp.role_id IN `
+		permissionsSetPart string = " AND rp.permission_number IN "
+		queryEnd string = " ORDER BY rec.depth ASC, roles.priority_order ASC"
 	)
-	queryStringSize := len(queryStart) + len(permissionsSetPart) + len(queryEnd) + len(roleIdSet) + len(permissionIdSet)
+	permissionIdSet := ""
+	if permissions != nil {
+		if len(permissions) < 1 {
+			return ResolvedPermissions{}, nil
+		}
+		permissionIdSet = idSetString(permissions)
+	}
+	queryStringSize := len(queryStart) + len(queryEnd) + len(roleIdSet)
+	if len(permissionIdSet) > 0 {
+		queryStringSize += len(permissionsSetPart) + len(permissionIdSet)
+	}
 	b := strings.Builder{}
 	b.Grow(queryStringSize)
 	b.WriteString(queryStart)
 	b.WriteString(roleIdSet)
-	b.WriteString(permissionsSetPart)
-	b.WriteString(permissionIdSet)
+	if len(permissionIdSet) > 0 {
+		b.WriteString(permissionsSetPart)
+		b.WriteString(permissionIdSet)
+	}
 	b.WriteString(queryEnd)
-	queryString := b.String()
 
-	rows, err := MainDB.Query(queryString, id)
-	if err == sql.ErrNoRows {
-		rows = nil
-	} else if err != nil {
+	rows, err := MainDB.Query(b.String(), id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return ResolvedPermissions{}, nil
+		}
 		return nil, err
 	}
-	permList := make(PermissionsList)
+	defer rows.Close()
 
-	for i := 0; i <= len(parents); i++ {
-		if rows != nil {
-			var (
-				permissionNumber PermissionNumber
-				granted bool
-			)
-			for rows.Next() {
-				if err := rows.Scan(&permissionNumber, &granted); err != nil {
-					defer rows.Close()
-					return nil, err
-				} else if _, ok := permList[permissionNumber]; !ok {
-					permList[permissionNumber] = granted
-				}
-			}
-			rows.Close()
-		}
-		if i < len(parents) {
-			rows, err = MainDB.Query(queryString, parents[i])
-			if err == sql.ErrNoRows {
-				rows = nil
-			} else if err != nil {
-				return nil, err
-			}
+	permissionRows := make([]rolePermissionRow, 0)
+	for rows.Next() {
+		var row rolePermissionRow
+		if err := rows.Scan(&row.Permission, &row.Granted, &row.CircleId, &row.Rank.Depth, &row.RoleId, &row.RoleName, &row.Rank.Order); err != nil {
+			return nil, err
 		}
+		permissionRows = append(permissionRows, row)
 	}
+	return resolveRolePermissionRows(permissionRows), nil
+}
 
-	return permList, nil
+//a role's setting for a permission, ranked by the circle it's set in
+type rolePermissionRow struct {
+	Permission PermissionNumber
+	Granted bool
+	CircleId CircleId
+	Rank RoleRank
+	RoleId RoleId
+	RoleName string
 }
 
-func GetAllPermissions(id CircleId, roles ...RoleId) (PermissionsList, error) {
-	roleIdSet := idSetString(roles)
-	if len(roleIdSet) < 1 {
-		return nil, nil
+//the highest ranked row decides each permission, the first row wins a tie
+func resolveRolePermissionRows(rows []rolePermissionRow) ResolvedPermissions {
+	resolved := make(ResolvedPermissions)
+	ranks := make(map[PermissionNumber]RoleRank)
+	for _, row := range rows {
+		if rank, ok := ranks[row.Permission]; ok && !row.Rank.Above(rank) {
+			continue
+		}
+		source := PermissionSource{}
+		source.setRole(row.Granted, row.CircleId, row.RoleId, row.RoleName, row.Rank.Order, row.Rank.Depth > 0)
+		resolved[row.Permission] = source
+		ranks[row.Permission] = row.Rank
 	}
-	parents, err := GetAllCircleParents(id)
+	return resolved
+}
+
+//finds the member overrides that decide each permission for the account, the nearest circle's override wins.
+//these take precedence over anything ResolvePermissions finds
+func ResolveMemberPermissions(account AccountId, circle CircleId) (ResolvedPermissions, error) {
+	rows, err := MainDB.Query(
+		CIRCLE_ANCESTORS_CTE + ` SELECT mp.permission_number, mp.granted, rec.id, rec.depth FROM rec
+			INNER JOIN circle_members m ON m.circle_id=rec.id INNER JOIN member_permissions mp ON mp.circle_member_id=m.id
+			WHERE m.account_id=? ORDER BY rec.depth ASC`,
+		circle, account,
+	)
 	if err != nil {
+		if err == sql.ErrNoRows {
+			return ResolvedPermissions{}, nil
+		}
 		return nil, err
 	}
+	defer rows.Close()
+
+	resolved := make(ResolvedPermissions)
+	for rows.Next() {
+		var (
+			permissionNumber PermissionNumber
+			granted bool
+			circleId CircleId
+			depth int
+		)
+		if err := rows.Scan(&permissionNumber, &granted, &circleId, &depth); err != nil {
+			return nil, err
+		} else if _, ok := resolved[permissionNumber]; !ok {
+			resolved[permissionNumber] = PermissionSource{Granted: granted, Reason: PERMISSION_REASON_MEMBER, CircleId: circleId, Inherited: depth > 0}
+		}
+	}
+	return resolved, nil
+}
 
-	queryString := strings.Join([]string{
-		"SELECT role_permissions.permission_number, role_permissions.granted FROM role_permissions INNER JOIN roles ON role_permissions.role_id=roles.id WHERE role_permissions.circle_id=? AND role_permissions.role_id IN ",
-		" ORDER BY roles.priority_order ASC",
-	}, roleIdSet)
+func GetSomePermissions(id CircleId, roles []RoleId, permissions []PermissionNumber) (PermissionsList, error) {
+	if permissions == nil {
+		permissions = []PermissionNumber{}
+	}
+	resolved, err := ResolvePermissions(id, roles, permissions)
+	if err != nil {
+		return nil, err
+	}
+	return FlattenPermissions(resolved), nil
+}
 
-	rows, err := MainDB.Query(queryString, id)
-	if err == sql.ErrNoRows {
-		rows = nil
-	} else if err != nil {
+func GetAllPermissions(id CircleId, roles ...RoleId) (PermissionsList, error) {
+	if len(roles) < 1 {
+		return nil, nil
+	}
+	resolved, err := ResolvePermissions(id, roles, nil)
+	if err != nil {
 		return nil, err
 	}
-	permList := make(PermissionsList)
-	
-	for i := 0; i <= len(parents); i++ {
-		if rows != nil {
-			var (
-				permissionNumber PermissionNumber
-				granted bool
-			)
-			for rows.Next() {
-				if err := rows.Scan(&permissionNumber, &granted); err != nil {
-					defer rows.Close()
-					return nil, err
-				} else if _, ok := permList[permissionNumber]; !ok {
-					permList[permissionNumber] = granted
-				}
-			}
-			rows.Close()
-		}
-		if i < len(parents) {
-			rows, err = MainDB.Query(queryString, parents[i])
-			if err == sql.ErrNoRows {
-				rows = nil
-			} else if err != nil {
-				return nil, err
+	return FlattenPermissions(resolved), nil
+}
+
+//administrators get everything no matter what their other roles deny
+func applyAdministrator(resolved ResolvedPermissions) {
+	if admin, ok := resolved[PERM_ADMINISTRATOR.Number]; ok && admin.Granted {
+		for _, p := range PermissionRegistry.All() {
+			if p.Number != PERM_ADMINISTRATOR.Number {
+				source := admin
+				source.Reason = PERMISSION_REASON_ADMINISTRATOR
+				resolved[p.Number] = source
 			}
 		}
 	}
+}
 
-	return permList, nil
+//resolves the permissions a member with only these roles would have, the same way it's done for accounts.
+//roles from parents the circle doesn't inherit from are left out
+func SimulateRolePermissions(circle CircleId, roles []RoleId) (ResolvedPermissions, error) {
+	roleIdSet := idSetString(roles)
+	if len(roleIdSet) < 1 {
+		return ResolvedPermissions{}, nil
+	}
+	inherited, err := queryIdSet(MainDB,
+		CIRCLE_ANCESTORS_CTE + " SELECT r.id FROM rec INNER JOIN roles r ON r.circle_id=rec.id WHERE r.id IN " + roleIdSet + " AND " + INHERITED_ROLES_CONDITION,
+		circle,
+	)
+	if err != nil {
+		return nil, err
+	}
+	resolved, err := ResolvePermissions(circle, inherited, nil)
+	if err != nil {
+		return nil, err
+	}
+	applyAdministrator(resolved)
+	return resolved, nil
 }
 
 func GetAllCircleParents(id CircleId) ([]CircleId, error) {
@@ -405,181 +476,460 @@ func GetAllCircleParents(id CircleId) ([]CircleId, error) {
 	return ids, nil
 }
 
-func GetAccountRoles(account AccountId, circle CircleId) ([]RoleId, error) {
-    const queryString string = "SELECT id FROM roles r WHERE EXISTS(SELECT 1 FROM role_members INNER JOIN circle_members ON circle_members.account_id=? AND circle_members.circle_id=? WHERE role_members.role_id=r.id AND role_members.circle_member_id=circle_members.id)"
-	parents, err := GetAllCircleParents(circle)
+func GetAllCircleChildren(id CircleId) ([]CircleId, error) {
+	//gets children in order of nearest to furthest
+	rows, err := MainDB.Query(
+		`WITH RECURSIVE rec AS (
+			SELECT id, 0 AS depth FROM circles
+			WHERE parent_id=?
+			UNION ALL SELECT c.id, (r.depth+1) FROM circles c JOIN rec r ON c.parent_id = r.id
+			) SELECT id, depth FROM rec ORDER BY depth ASC;`,
+		id,
+	)
 	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
 		return nil, err
 	}
+	defer rows.Close()
 
-    rows, err := MainDB.Query(queryString, account, circle)
-    if err == sql.ErrNoRows {
-        rows = nil
-    } else if err != nil {
-        return nil, err
-    }
-
-    roleList := make([]RoleId, 0)
-
-    for i := 0; i <= len(parents); i++ {
-		if rows != nil {
-			var roleId RoleId
-			for rows.Next() {
-				if err := rows.Scan(&roleId); err != nil {
-					defer rows.Close()
-					return nil, err
-				}
-                roleList = append(roleList, roleId)
-			}
-			rows.Close()
-		}
-		if i < len(parents) {
-			rows, err = MainDB.Query(queryString, account, circle)
-			if err == sql.ErrNoRows {
-				rows = nil
-			} else if err != nil {
-				return nil, err
-			}
+	ids := make([]CircleId, 0)
+	for rows.Next() {
+		var (
+			childId CircleId
+			depth int64
+		)
+		if err := rows.Scan(&childId, &depth); err != nil {
+			return nil, err
 		}
+		ids = append(ids, childId)
 	}
+	return ids, nil
+}
+
+//condition on CIRCLE_ANCESTORS_CTE rows for the circles roles are inherited from.
+//a circle that doesn't inherit roles still keeps its own, but cuts off everything above it
+const INHERITED_ROLES_CONDITION string = "rec.depth <= (SELECT COALESCE(MIN(rec2.depth), rec.depth) FROM rec rec2 INNER JOIN circles c2 ON c2.id=rec2.id WHERE c2.inherit_roles=FALSE)"
 
-    return roleList, nil
+//selects the roles the account holds in the circle and the parents it inherits roles from, nearest circle first
+const ACCOUNT_ROLES_QUERY string = CIRCLE_ANCESTORS_CTE + ` SELECT r.id, r.circle_id, r.priority_order, r.name, r.color FROM rec
+	INNER JOIN circle_members m ON m.circle_id=rec.id INNER JOIN role_members rm ON rm.circle_member_id=m.id INNER JOIN roles r ON r.id=rm.role_id
+	WHERE m.account_id=? AND ` + INHERITED_ROLES_CONDITION + `
+	ORDER BY rec.depth ASC, r.priority_order ASC`
+
+func GetAccountRoles(account AccountId, circle CircleId) ([]RoleId, error) {
+	roles, err := GetAccountRolesInfo(account, circle)
+	if err != nil {
+		return nil, err
+	}
+	roleList := make([]RoleId, len(roles))
+	for i := range roles {
+		roleList[i] = roles[i].Id
+	}
+	return roleList, nil
 }
 
 func GetAccountRolesInfo(account AccountId, circle CircleId) ([]RoleInfo, error) {
-	const queryString string = "SELECT id, circle_id, priority_order, name, color FROM roles r WHERE EXISTS(SELECT 1 FROM role_members INNER JOIN circle_members ON circle_members.account_id=? AND circle_members.circle_id=? WHERE role_members.role_id=r.id AND role_members.circle_member_id=circle_members.id)"
-	parents, err := GetAllCircleParents(circle)
+	rows, err := MainDB.Query(ACCOUNT_ROLES_QUERY, circle, account)
 	if err != nil {
+		if err == sql.ErrNoRows {
+			return []RoleInfo{}, nil
+		}
 		return nil, err
 	}
+	defer rows.Close()
 
-    rows, err := MainDB.Query(queryString, account, circle)
-    if err == sql.ErrNoRows {
-        rows = nil
-    } else if err != nil {
-        return nil, err
-    }
-
-    roleList := make([]RoleInfo, 0)
-
-    for i := 0; i <= len(parents); i++ {
-		if rows != nil {
-			
-			for rows.Next() {
-				var (
-					roleInfo RoleInfo
-					color []byte
-				)
-				if err := rows.Scan(&roleInfo.Id, &roleInfo.CircleId, &roleInfo.Order, &roleInfo.Name, &color); err != nil {
-					defer rows.Close()
-					return nil, err
-				}
-				if color != nil {
-					roleInfo.Color = color
-				} else {
-					copy(roleInfo.Color, DEFAULT_ROLE_COLOR)
-				}
-                roleList = append(roleList, roleInfo)
-			}
-			rows.Close()
+	roleList := make([]RoleInfo, 0)
+	for rows.Next() {
+		var (
+			roleInfo RoleInfo
+			color []byte
+		)
+		if err := rows.Scan(&roleInfo.Id, &roleInfo.CircleId, &roleInfo.Order, &roleInfo.Name, &color); err != nil {
+			return nil, err
 		}
-		if i < len(parents) {
-			rows, err = MainDB.Query(queryString, account, circle)
-			if err == sql.ErrNoRows {
-				rows = nil
-			} else if err != nil {
-				return nil, err
-			}
+		if color != nil {
+			roleInfo.Color = color
+		} else {
+			roleInfo.Color = append([]uint8(nil), DEFAULT_ROLE_COLOR...)
 		}
+		roleList = append(roleList, roleInfo)
 	}
-
 	return roleList, nil
 }
 
-//check for permissions before calling
-func CreateCircle(circle CircleInfo, roles []RoleInfo, permissions map[string]PermissionsList) (CircleId, error) {
-	var circleId CircleId = 0
-
-	var row *sql.Row
-	if circle.ParentId == nil {
-		row = MainDB.QueryRow("SELECT id FROM circles WHERE parent_id is NULL AND name=?", circle.Name)
+func GetRoleInfo(id RoleId) (*RoleInfo, error) {
+	roleInfo := &RoleInfo{Id: id}
+	var color []byte
+	row := MainDB.QueryRow("SELECT circle_id, priority_order, name, color FROM roles WHERE id=?", id)
+	if err := row.Scan(&roleInfo.CircleId, &roleInfo.Order, &roleInfo.Name, &color); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if color != nil {
+		roleInfo.Color = color
 	} else {
-		row = MainDB.QueryRow("SELECT id FROM circles WHERE parent_id=? AND name=?", circle.ParentId, circle.Name)
+		roleInfo.Color = append([]uint8(nil), DEFAULT_ROLE_COLOR...)
 	}
-	var checkId CircleId
-	err := row.Scan(&checkId)
-	if err == nil {
-		if circle.ParentId == nil {
-			return checkId, &DuplicateCircleNameError{message: fmt.Sprintf("duplicate circle name %s at root", circle.Name)}
-		} else {
-			return checkId, &DuplicateCircleNameError{message: fmt.Sprintf("duplicate circle name %s in parent circle %d", circle.Name, *circle.ParentId)}
+	return roleInfo, nil
+}
+
+func GetEveryoneRole(circle CircleId) (RoleId, error) {
+	var roleId RoleId
+	row := MainDB.QueryRow("SELECT id FROM roles WHERE circle_id=? AND name=?", circle, ROLE_NAME_EVERYONE)
+	if err := row.Scan(&roleId); err != nil {
+		if err == sql.ErrNoRows {
+			return 0, nil
 		}
-	} else if err != sql.ErrNoRows {
 		return 0, err
 	}
+	return roleId, nil
+}
 
-	tx, err := MainDB.Begin()
+//roles used when checking an account's permissions, the circle's ::everyone role applies to everyone
+//including non-members and members who only hold roles inherited from parents
+func GetAccountPermissionRoles(account AccountId, circle CircleId) ([]RoleId, error) {
+	roles, err := GetAccountRoles(account, circle)
 	if err != nil {
-		return 0, err
+		return nil, err
 	}
-	//commit := true
-	defer func() {
-		//will assign to err before it gets returned
-		if err == nil {
-			if err = tx.Commit(); err != nil {
-				circleId = 0
-			}
-		} else {
-			circleId = 0
-			if e := tx.Rollback(); e != nil {
-				err = e
-			}
+	everyoneId, err := GetEveryoneRole(circle)
+	if err != nil {
+		return nil, err
+	} else if everyoneId == 0 {
+		return roles, nil
+	}
+	for _, roleId := range roles {
+		if roleId == everyoneId {
+			return roles, nil
 		}
-	}()
+	}
+	return append(roles, everyoneId), nil
+}
 
-	var defaultSubcirclePermissions []byte = nil
-	if circle.DefaultSubcirclePermissions != nil {
-		defaultSubcirclePermissions = PermissionsToBytes(circle.DefaultSubcirclePermissions)
+func GetAccountPermissions(account AccountId, circle CircleId) (PermissionsList, error) {
+	resolved, err := ExplainAccountPermissions(account, circle)
+	if err != nil {
+		return nil, err
 	}
+	return FlattenPermissions(resolved), nil
+}
 
-	r, err := tx.Exec(`INSERT INTO circles (parent_id, owner_id, name, com_type, default_subcircle_com_type, default_subcircle_permissions)
-				VALUES(?, ?, ?, ?, ?, ?)`,
-		circle.ParentId, circle.OwnerId, circle.Name, circle.ComType, circle.DefaultSubcircleComType, defaultSubcirclePermissions,
+//gets the nearest of the circle and its parents that's owned by the account, 0 if it doesn't own any of them
+func GetOwnedCircle(account AccountId, circle CircleId) (CircleId, error) {
+	var ownedId CircleId
+	row := MainDB.QueryRow(
+		CIRCLE_ANCESTORS_CTE + " SELECT rec.id FROM rec INNER JOIN circles ON circles.id=rec.id WHERE circles.owner_id=? ORDER BY rec.depth ASC LIMIT 1",
+		circle, account,
 	)
-	if err != nil {
+	if err := row.Scan(&ownedId); err != nil {
+		if err == sql.ErrNoRows {
+			return 0, nil
+		}
 		return 0, err
 	}
-	circleId, err = r.LastInsertId()
+	return ownedId, nil
+}
+
+//same as GetAccountPermissions, but keeps track of what decided each permission
+func ExplainAccountPermissions(account AccountId, circle CircleId) (ResolvedPermissions, error) {
+	if resolved, ok := PermissionCache.Get(account, circle); ok {
+		return resolved, nil
+	}
+	generation := PermissionCache.Generation()
+	resolved, until, err := explainAccountPermissions(account, circle)
 	if err != nil {
-		return 0, err
+		return nil, err
 	}
+	PermissionCache.Put(account, circle, resolved, until, generation)
+	return resolved, nil
+}
 
-	roleNames := make(map[string]RoleId, len(roles))
-	for _, role := range roles {
-		//check for duplicate names
-		if _, ok := roleNames[role.Name]; ok {
-			err = &DuplicateRoleNameError{message: fmt.Sprintf("duplicate role name %s for circle %d", role.Name, circleId)}
-			return 0, err
-		}
-		//add role to circle
-		r, err = tx.Exec(`INSERT INTO roles (circle_id, priority_order, name, color) VALUES(?, ?, ?, ?)`, circleId, role.Order, role.Name, role.Color)
-		if err != nil {
-			return 0, err
+//resolves permissions without the cache, also giving the time the result stops being valid (nil if it's only
+//invalidated by changes)
+func explainAccountPermissions(account AccountId, circle CircleId) (ResolvedPermissions, *time.Time, error) {
+	//nothing is allowed in circles that are in the trash
+	var active bool
+	row := MainDB.QueryRow("SELECT deleted_id IS NULL FROM circles WHERE id=?", circle)
+	if err := row.Scan(&active); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil, nil
 		}
-		var roleId int64
-		roleId, err = r.LastInsertId()
-		if err != nil {
-			return 0, err
+		return nil, nil, err
+	} else if !active {
+		return nil, nil, nil
+	}
+	var (
+		inputs accountPermissionInputs
+		bannedUntil, mutedUntil *time.Time
+		err error
+	)
+	//the lookups stop as soon as something decides the result on its own
+	inputs.OwnedCircle, err = GetOwnedCircle(account, circle)
+	if err != nil {
+		return nil, nil, err
+	} else if inputs.OwnedCircle != 0 {
+		return combineAccountPermissions(circle, inputs), nil, nil
+	}
+	inputs.Banned, bannedUntil, err = getActiveRestriction(MainDB, "circle_bans", account, circle)
+	if err != nil {
+		return nil, nil, err
+	} else if inputs.Banned {
+		return combineAccountPermissions(circle, inputs), bannedUntil, nil
+	}
+	roles, err := GetAccountPermissionRoles(account, circle)
+	if err != nil {
+		return nil, nil, err
+	} else if len(roles) < 1 {
+		return nil, nil, nil
+	}
+	if inputs.Roles, err = ResolvePermissions(circle, roles, nil); err != nil {
+		return nil, nil, err
+	}
+	if inputs.Overrides, err = ResolveMemberPermissions(account, circle); err != nil {
+		return nil, nil, err
+	}
+	inputs.Muted, mutedUntil, err = getActiveRestriction(MainDB, "circle_mutes", account, circle)
+	if err != nil {
+		return nil, nil, err
+	}
+	return combineAccountPermissions(circle, inputs), mutedUntil, nil
+}
+
+//everything explainAccountPermissions looks up about an account before putting its permissions together
+type accountPermissionInputs struct {
+	OwnedCircle CircleId //the circle or parent the account owns, 0 if none
+	Banned bool
+	Roles ResolvedPermissions //from ResolvePermissions, nil if the account isn't a member
+	Overrides ResolvedPermissions //from ResolveMemberPermissions
+	Muted bool
+}
+
+//from strongest to weakest: owning the circle, being banned, being muted (only for PERMS_MUTED), a granted
+//administrator permission, member overrides, then roles
+func combineAccountPermissions(circle CircleId, inputs accountPermissionInputs) ResolvedPermissions {
+	//owners can't lock themselves out of their circles
+	if inputs.OwnedCircle != 0 {
+		all := PermissionRegistry.All()
+		resolved := make(ResolvedPermissions, len(all))
+		for _, p := range all {
+			resolved[p.Number] = PermissionSource{Granted: true, Reason: PERMISSION_REASON_OWNER, CircleId: inputs.OwnedCircle, Inherited: inputs.OwnedCircle != circle}
 		}
-		roleNames[role.Name] = roleId //keep track of used names
+		return resolved
+	} else if inputs.Banned || inputs.Roles == nil {
+		return nil
 	}
 
-	if _, ok := roleNames[ROLE_NAME_EVERYONE]; !ok {
-		r, err = tx.Exec(`INSERT INTO roles (circle_id, priority_order, name, color) VALUES(?, ?, ?, ?)`, circleId, (1<<31)-1, ROLE_NAME_EVERYONE, nil)
-		if err != nil {
-			return 0, err
+	resolved := copyResolvedPermissions(inputs.Roles)
+	for n, source := range inputs.Overrides {
+		resolved[n] = source
+	}
+	applyAdministrator(resolved)
+	if inputs.Muted {
+		for _, p := range PERMS_MUTED {
+			resolved[p.Number] = PermissionSource{Granted: false, Reason: PERMISSION_REASON_MUTED, CircleId: circle}
 		}
-		var roleId int64
+	}
+	return resolved
+}
+
+func CanViewCircle(account AccountId, circle CircleId) (bool, error) {
+	permissions, err := GetAccountPermissions(account, circle)
+	if err != nil {
+		return false, err
+	}
+	return permissions[PERM_VIEW_CIRCLE.Number], nil
+}
+
+//gets the permissions that roles will be given in new subcircles of the circle
+func GetDefaultSubcircleRolePermissions(circle CircleId) (map[RoleId]PermissionsList, error) {
+	rows, err := MainDB.Query("SELECT role_id, permission_number, granted FROM default_subcircle_role_permissions WHERE circle_id=?", circle)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer rows.Close()
+
+	defaults := make(map[RoleId]PermissionsList)
+	for rows.Next() {
+		var (
+			roleId RoleId
+			permissionNumber PermissionNumber
+			granted bool
+		)
+		if err := rows.Scan(&roleId, &permissionNumber, &granted); err != nil {
+			return nil, err
+		}
+		permList, ok := defaults[roleId]
+		if !ok {
+			permList = make(PermissionsList)
+			defaults[roleId] = permList
+		}
+		permList[permissionNumber] = granted
+	}
+	return defaults, nil
+}
+
+//check for permissions before calling
+func SetDefaultSubcircleComType(circle CircleId, comType *CommunicationType) error {
+	_, err := MainDB.Exec("UPDATE circles SET default_subcircle_com_type=? WHERE id=?", comType, circle)
+	return err
+}
+
+//check for permissions before calling
+func SetDefaultSubcirclePermissions(circle CircleId, permissions PermissionsList) error {
+	var rawPermissions []byte = nil
+	if len(permissions) > 0 {
+		rawPermissions = PermissionsToBytes(permissions)
+	}
+	_, err := MainDB.Exec("UPDATE circles SET default_subcircle_permissions=? WHERE id=?", rawPermissions, circle)
+	return err
+}
+
+//check for permissions before calling, replaces the role's existing defaults
+func SetDefaultSubcircleRolePermissions(circle CircleId, role RoleId, permissions PermissionsList) (err error) {
+	tx, err := MainDB.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err == nil {
+			err = tx.Commit()
+		} else if e := tx.Rollback(); e != nil {
+			err = e
+		}
+	}()
+
+	if _, err = tx.Exec("DELETE FROM default_subcircle_role_permissions WHERE circle_id=? AND role_id=?", circle, role); err != nil {
+		return err
+	}
+	for permNum, granted := range permissions {
+		_, err = tx.Exec(
+			"INSERT INTO default_subcircle_role_permissions (circle_id, role_id, permission_number, granted) VALUES(?, ?, ?, ?)",
+			circle, role, permNum, granted,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//checks if the role belongs to the circle or one of its parents
+func IsRoleInCircleTree(role RoleId, circle CircleId) (bool, error) {
+	var roleCircleId CircleId
+	row := MainDB.QueryRow("SELECT circle_id FROM roles WHERE id=?", role)
+	if err := row.Scan(&roleCircleId); err != nil {
+		if err == sql.ErrNoRows {
+			return false, nil
+		}
+		return false, err
+	}
+	if roleCircleId == circle {
+		return true, nil
+	}
+	parents, err := GetAllCircleParents(circle)
+	if err != nil {
+		return false, err
+	}
+	for _, parentId := range parents {
+		if parentId == roleCircleId {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+//check for permissions before calling
+func CreateCircle(circle CircleInfo, roles []RoleInfo, permissions map[string]PermissionsList) (CircleId, error) {
+	var circleId CircleId = 0
+
+	var row *sql.Row
+	if circle.ParentId == nil {
+		row = MainDB.QueryRow("SELECT id FROM circles WHERE parent_id is NULL AND name=? AND deleted_id IS NULL", circle.Name)
+	} else {
+		row = MainDB.QueryRow("SELECT id FROM circles WHERE parent_id=? AND name=? AND deleted_id IS NULL", circle.ParentId, circle.Name)
+	}
+	var checkId CircleId
+	err := row.Scan(&checkId)
+	if err == nil {
+		if circle.ParentId == nil {
+			return checkId, &DuplicateCircleNameError{message: fmt.Sprintf("duplicate circle name %s at root", circle.Name)}
+		} else {
+			return checkId, &DuplicateCircleNameError{message: fmt.Sprintf("duplicate circle name %s in parent circle %d", circle.Name, *circle.ParentId)}
+		}
+	} else if err != sql.ErrNoRows {
+		return 0, err
+	}
+
+	defer InvalidatePermissionCache()
+	tx, err := MainDB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	//commit := true
+	defer func() {
+		//will assign to err before it gets returned
+		if err == nil {
+			if err = tx.Commit(); err != nil {
+				circleId = 0
+			}
+		} else {
+			circleId = 0
+			if e := tx.Rollback(); e != nil {
+				err = e
+			}
+		}
+	}()
+
+	var defaultSubcirclePermissions []byte = nil
+	if circle.DefaultSubcirclePermissions != nil {
+		defaultSubcirclePermissions = PermissionsToBytes(circle.DefaultSubcirclePermissions)
+	}
+
+	r, err := tx.Exec(`INSERT INTO circles (parent_id, owner_id, name, com_type, default_subcircle_com_type, default_subcircle_permissions)
+				VALUES(?, ?, ?, ?, ?, ?)`,
+		circle.ParentId, circle.OwnerId, circle.Name, circle.ComType, circle.DefaultSubcircleComType, defaultSubcirclePermissions,
+	)
+	if err != nil {
+		return 0, err
+	}
+	circleId, err = r.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	roleNames := make(map[string]RoleId, len(roles))
+	for _, role := range roles {
+		//check for duplicate names
+		if _, ok := roleNames[role.Name]; ok {
+			err = &DuplicateRoleNameError{message: fmt.Sprintf("duplicate role name %s for circle %d", role.Name, circleId)}
+			return 0, err
+		}
+		//add role to circle
+		r, err = tx.Exec(`INSERT INTO roles (circle_id, priority_order, name, color) VALUES(?, ?, ?, ?)`, circleId, role.Order, role.Name, role.Color)
+		if err != nil {
+			return 0, err
+		}
+		var roleId int64
+		roleId, err = r.LastInsertId()
+		if err != nil {
+			return 0, err
+		}
+		roleNames[role.Name] = roleId //keep track of used names
+	}
+
+	if _, ok := roleNames[ROLE_NAME_EVERYONE]; !ok {
+		r, err = tx.Exec(`INSERT INTO roles (circle_id, priority_order, name, color) VALUES(?, ?, ?, ?)`, circleId, ROLE_ORDER_LOWEST, ROLE_NAME_EVERYONE, nil)
+		if err != nil {
+			return 0, err
+		}
+		var roleId int64
 		roleId, err = r.LastInsertId()
 		if err != nil {
 			return 0, err
@@ -587,6 +937,45 @@ func CreateCircle(circle CircleInfo, roles []RoleInfo, permissions map[string]Pe
 		roleNames[ROLE_NAME_EVERYONE] = roleId
 	}
 
+	if circle.ParentId != nil {
+		//seed permissions from the parent's defaults, anything given explicitly takes priority
+		var rawDefaultPermissions []byte
+		row := tx.QueryRow("SELECT default_subcircle_permissions FROM circles WHERE id=? AND deleted_id IS NULL", *circle.ParentId)
+		if err = row.Scan(&rawDefaultPermissions); err != nil {
+			return 0, err
+		}
+		seeded := make(map[string]PermissionsList, len(permissions)+1)
+		for roleName, permList := range permissions {
+			seeded[roleName] = permList
+		}
+		defaultPermissions := readDefaultSubcirclePermissions(*circle.ParentId, rawDefaultPermissions)
+		if len(defaultPermissions) > 0 {
+			everyonePermissions := make(PermissionsList, len(defaultPermissions))
+			for permNum, granted := range defaultPermissions {
+				everyonePermissions[permNum] = granted
+			}
+			for permNum, granted := range permissions[ROLE_NAME_EVERYONE] {
+				everyonePermissions[permNum] = granted
+			}
+			seeded[ROLE_NAME_EVERYONE] = everyonePermissions
+		}
+		permissions = seeded
+
+		var roleDefaults map[RoleId]PermissionsList
+		roleDefaults, err = GetDefaultSubcircleRolePermissions(*circle.ParentId)
+		if err != nil {
+			return 0, err
+		}
+		for roleId, permList := range roleDefaults {
+			for permNum, granted := range permList {
+				_, err = tx.Exec(`INSERT INTO role_permissions (role_id, circle_id, permission_number, granted) VALUES(?, ?, ?, ?)`, roleId, circleId, permNum, granted)
+				if err != nil {
+					return 0, err
+				}
+			}
+		}
+	}
+
 	for roleName, permList := range permissions {
 		roleId, ok := roleNames[roleName]
 		if !ok {
@@ -607,6 +996,561 @@ func CreateCircle(circle CircleInfo, roles []RoleInfo, permissions map[string]Pe
 	return circleId, err //returning err to allow the deferred function to modify the error value, but it is initially nil
 }
 
+type CircleCycleError struct {
+	message string
+}
+func (err *CircleCycleError) Error() string {
+	return err.message
+}
+
+//finds an active circle with the name under the parent, 0 if there isn't one. locks what it reads until the transaction ends
+func findCircleByName(tx *sql.Tx, parent *CircleId, name string) (CircleId, error) {
+	var row *sql.Row
+	if parent == nil {
+		row = tx.QueryRow("SELECT id FROM circles WHERE parent_id IS NULL AND name=? AND deleted_id IS NULL FOR UPDATE", name)
+	} else {
+		row = tx.QueryRow("SELECT id FROM circles WHERE parent_id=? AND name=? AND deleted_id IS NULL FOR UPDATE", *parent, name)
+	}
+	var checkId CircleId
+	if err := row.Scan(&checkId); err != nil {
+		if err == sql.ErrNoRows {
+			return 0, nil
+		}
+		return 0, err
+	}
+	return checkId, nil
+}
+
+type CircleInheritance struct {
+	InheritRoles bool //roles held in parent circles apply in this circle
+	FollowParentMembership bool //joining or leaving the parent circle joins or leaves this circle too
+}
+
+func GetCircleInheritance(id CircleId) (*CircleInheritance, error) {
+	inheritance := &CircleInheritance{}
+	row := MainDB.QueryRow("SELECT inherit_roles, follow_parent_membership FROM circles WHERE id=? AND deleted_id IS NULL", id)
+	if err := row.Scan(&inheritance.InheritRoles, &inheritance.FollowParentMembership); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return inheritance, nil
+}
+
+//check for permissions before calling, nil values are left unchanged.
+//when the circle starts following its parent's membership, the parent's members are added to it
+func SetCircleInheritance(id CircleId, inheritRoles *bool, followParentMembership *bool, actor AccountId) (err error) {
+	info, err := GetCircleInfo(id)
+	if err != nil {
+		return err
+	} else if info == nil {
+		return sql.ErrNoRows
+	}
+	previous, err := GetCircleInheritance(id)
+	if err != nil {
+		return err
+	}
+
+	defer InvalidatePermissionCache()
+	tx, err := MainDB.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err == nil {
+			err = tx.Commit()
+		} else if e := tx.Rollback(); e != nil {
+			err = e
+		}
+	}()
+
+	if inheritRoles != nil {
+		if _, err = tx.Exec("UPDATE circles SET inherit_roles=? WHERE id=?", *inheritRoles, id); err != nil {
+			return err
+		}
+	}
+	if followParentMembership != nil {
+		if _, err = tx.Exec("UPDATE circles SET follow_parent_membership=? WHERE id=?", *followParentMembership, id); err != nil {
+			return err
+		}
+		if *followParentMembership && !previous.FollowParentMembership && info.ParentId != nil {
+			var accounts []AccountId
+			accounts, err = queryIdSet(tx, "SELECT account_id FROM circle_members WHERE circle_id=?", *info.ParentId)
+			if err != nil {
+				return err
+			}
+			for _, account := range accounts {
+				if _, err = joinCircleTx(tx, account, id, nil, actor); err != nil {
+					switch err.(type) {
+					case *AlreadyMemberError, *BannedError:
+						err = nil
+						continue
+					}
+					return err
+				}
+			}
+		}
+	}
+
+	updated := *previous
+	if inheritRoles != nil {
+		updated.InheritRoles = *inheritRoles
+	}
+	if followParentMembership != nil {
+		updated.FollowParentMembership = *followParentMembership
+	}
+	return writeAudit(tx, id, &actor, AUDIT_CIRCLE_INHERITANCE_UPDATE, nil, previous, updated)
+}
+
+//locks the circle's row until the transaction ends, nil does nothing
+func lockCircle(tx *sql.Tx, id *CircleId) error {
+	if id == nil {
+		return nil
+	}
+	var lockedId CircleId
+	return tx.QueryRow("SELECT id FROM circles WHERE id=? FOR UPDATE", *id).Scan(&lockedId)
+}
+
+//check for permissions before calling
+func RenameCircle(id CircleId, name string) (err error) {
+	tx, err := MainDB.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err == nil {
+			err = tx.Commit()
+		} else if e := tx.Rollback(); e != nil {
+			err = e
+		}
+	}()
+
+	var parent *CircleId
+	row := tx.QueryRow("SELECT parent_id FROM circles WHERE id=? AND deleted_id IS NULL FOR UPDATE", id)
+	if err = row.Scan(&parent); err != nil {
+		return err
+	}
+	//names are unique per parent, so renames into the same parent wait on each other
+	if err = lockCircle(tx, parent); err != nil {
+		return err
+	}
+	checkId, err := findCircleByName(tx, parent, name)
+	if err != nil {
+		return err
+	} else if checkId != 0 && checkId != id {
+		return &DuplicateCircleNameError{message: fmt.Sprintf("duplicate circle name %s for renamed circle %d", name, id)}
+	}
+	_, err = tx.Exec("UPDATE circles SET name=? WHERE id=?", name, id)
+	return err
+}
+
+//walks up from the new parent and fails if it reaches the circle being moved. parentOf gets a circle's parent, nil for root circles
+func checkMoveCycle(id CircleId, parent CircleId, parentOf func(CircleId) (*CircleId, error)) error {
+	if parent == id {
+		return &CircleCycleError{message: fmt.Sprintf("circle %d cannot be its own parent", id)}
+	}
+	visited := make(map[CircleId]struct{})
+	for current := &parent; current != nil; {
+		if *current == id {
+			return &CircleCycleError{message: fmt.Sprintf("circle %d cannot be moved into its subcircle %d", id, parent)}
+		} else if _, ok := visited[*current]; ok {
+			return &CircleCycleError{message: fmt.Sprintf("parents of circle %d already loop at circle %d", parent, *current)}
+		}
+		visited[*current] = struct{}{}
+		next, err := parentOf(*current)
+		if err != nil {
+			return err
+		}
+		current = next
+	}
+	return nil
+}
+
+//check for permissions before calling, a nil parent makes the circle a root circle
+func MoveCircle(id CircleId, parent *CircleId) (err error) {
+	defer InvalidatePermissionCache()
+	tx, err := MainDB.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err == nil {
+			err = tx.Commit()
+		} else if e := tx.Rollback(); e != nil {
+			err = e
+		}
+	}()
+
+	var name string
+	row := tx.QueryRow("SELECT name FROM circles WHERE id=? AND deleted_id IS NULL FOR UPDATE", id)
+	if err = row.Scan(&name); err != nil {
+		return err
+	}
+	if parent != nil {
+		//the new parent's ancestors stay locked until the update is committed, so a concurrent move can't slip a cycle in
+		err = checkMoveCycle(id, *parent, func(circle CircleId) (*CircleId, error) {
+			var parentOf *CircleId
+			err := tx.QueryRow("SELECT parent_id FROM circles WHERE id=? FOR UPDATE", circle).Scan(&parentOf)
+			return parentOf, err
+		})
+		if err != nil {
+			return err
+		}
+	}
+	checkId, err := findCircleByName(tx, parent, name)
+	if err != nil {
+		return err
+	} else if checkId != 0 && checkId != id {
+		return &DuplicateCircleNameError{message: fmt.Sprintf("duplicate circle name %s for moved circle %d", name, id)}
+	}
+	_, err = tx.Exec("UPDATE circles SET parent_id=? WHERE id=?", parent, id)
+	return err
+}
+
+type CircleDeletion struct {
+	Id int64
+	CircleId CircleId
+	AccountId AccountId
+	Deleted time.Time
+	PurgeAfter time.Time
+}
+
+type CircleRestoreError struct {
+	message string
+}
+func (err *CircleRestoreError) Error() string {
+	return err.message
+}
+
+//check for permissions before calling, moves the circle and its subcircles to the trash until CircleTrashPeriod ends
+func DeleteCircle(id CircleId, account AccountId) (deletion CircleDeletion, err error) {
+	children, err := GetAllCircleChildren(id)
+	if err != nil {
+		return deletion, err
+	}
+	ids := append([]CircleId{id}, children...)
+
+	defer InvalidatePermissionCache()
+	tx, err := MainDB.Begin()
+	if err != nil {
+		return deletion, err
+	}
+	defer func() {
+		if err == nil {
+			err = tx.Commit()
+		} else if e := tx.Rollback(); e != nil {
+			err = e
+		}
+	}()
+
+	deletion = CircleDeletion{
+		CircleId: id,
+		AccountId: account,
+		Deleted: time.Now(),
+	}
+	deletion.PurgeAfter = deletion.Deleted.Add(CircleTrashPeriod)
+	r, err := tx.Exec(
+		"INSERT INTO circle_deletions (circle_id, account_id, deleted, purge_after) VALUES(?, ?, ?, ?)",
+		deletion.CircleId, deletion.AccountId, deletion.Deleted, deletion.PurgeAfter,
+	)
+	if err != nil {
+		return deletion, err
+	}
+	deletion.Id, err = r.LastInsertId()
+	if err != nil {
+		return deletion, err
+	}
+	//subcircles that were already deleted keep their own deletion
+	_, err = tx.Exec("UPDATE circles SET deleted_id=? WHERE deleted_id IS NULL AND id IN " + idSetString(ids), deletion.Id)
+	return deletion, err
+}
+
+func GetCircleDeletion(id CircleId) (*CircleDeletion, error) {
+	deletion := &CircleDeletion{CircleId: id}
+	row := MainDB.QueryRow("SELECT id, account_id, deleted, purge_after FROM circle_deletions WHERE circle_id=?", id)
+	if err := row.Scan(&deletion.Id, &deletion.AccountId, &deletion.Deleted, &deletion.PurgeAfter); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return deletion, nil
+}
+
+//gets the deletions of the circle's direct children that can still be restored
+func GetCircleChildDeletions(parent CircleId) ([]CircleDeletion, error) {
+	rows, err := MainDB.Query(
+		"SELECT d.id, d.circle_id, d.account_id, d.deleted, d.purge_after FROM circle_deletions d INNER JOIN circles c ON c.id=d.circle_id WHERE c.parent_id=? ORDER BY d.deleted DESC",
+		parent,
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer rows.Close()
+
+	deletions := make([]CircleDeletion, 0)
+	for rows.Next() {
+		var deletion CircleDeletion
+		if err := rows.Scan(&deletion.Id, &deletion.CircleId, &deletion.AccountId, &deletion.Deleted, &deletion.PurgeAfter); err != nil {
+			return nil, err
+		}
+		deletions = append(deletions, deletion)
+	}
+	return deletions, nil
+}
+
+//check for permissions before calling, takes the circle and the subcircles deleted along with it out of the trash
+func RestoreCircle(id CircleId) (err error) {
+	deletion, err := GetCircleDeletion(id)
+	if err != nil {
+		return err
+	} else if deletion == nil {
+		return &CircleRestoreError{message: fmt.Sprintf("circle %d is not in the trash", id)}
+	}
+
+	var (
+		parentId *CircleId
+		name string
+	)
+	row := MainDB.QueryRow("SELECT parent_id, name FROM circles WHERE id=?", id)
+	if err = row.Scan(&parentId, &name); err != nil {
+		return err
+	}
+	if parentId != nil {
+		var parentActive bool
+		row = MainDB.QueryRow("SELECT deleted_id IS NULL FROM circles WHERE id=?", *parentId)
+		if err = row.Scan(&parentActive); err != nil {
+			return err
+		} else if !parentActive {
+			return &CircleRestoreError{message: fmt.Sprintf("parent circle %d of circle %d is in the trash", *parentId, id)}
+		}
+		row = MainDB.QueryRow("SELECT id FROM circles WHERE parent_id=? AND name=? AND deleted_id IS NULL", *parentId, name)
+	} else {
+		row = MainDB.QueryRow("SELECT id FROM circles WHERE parent_id IS NULL AND name=? AND deleted_id IS NULL", name)
+	}
+	var checkId CircleId
+	err = row.Scan(&checkId)
+	if err == nil {
+		return &DuplicateCircleNameError{message: fmt.Sprintf("duplicate circle name %s for restored circle %d", name, id)}
+	} else if err != sql.ErrNoRows {
+		return err
+	}
+
+	defer InvalidatePermissionCache()
+	tx, err := MainDB.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err == nil {
+			err = tx.Commit()
+		} else if e := tx.Rollback(); e != nil {
+			err = e
+		}
+	}()
+
+	if _, err = tx.Exec("UPDATE circles SET deleted_id=NULL WHERE deleted_id=?", deletion.Id); err != nil {
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM circle_deletions WHERE id=?", deletion.Id)
+	return err
+}
+
+func queryIdSet(queryer sqlQueryer, query string, args ...interface{}) ([]int64, error) {
+	rows, err := queryer.Query(query, args...)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := make([]int64, 0)
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+//permanently removes the circle, its subcircles and everything in them.
+//audit log entries are kept until the audit log sweeper expires them
+func PurgeCircle(id CircleId) (err error) {
+	children, err := GetAllCircleChildren(id)
+	if err != nil {
+		return err
+	}
+	circleIdSet := idSetString(append([]CircleId{id}, children...))
+
+	//custom emoji images are only removed once the purge is committed
+	var emojiImages []string
+	defer func() {
+		if err != nil {
+			return
+		}
+		for _, image := range emojiImages {
+			if e := os.Remove(filepath.Join(MEDIA_DIR, image)); e != nil {
+				App.Logger.Error(e)
+			}
+		}
+	}()
+
+	defer InvalidatePermissionCache()
+	tx, err := MainDB.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err == nil {
+			err = tx.Commit()
+		} else if e := tx.Rollback(); e != nil {
+			err = e
+		}
+	}()
+
+	emojiRows, err := tx.Query("SELECT image FROM circle_emojis WHERE circle_id IN " + circleIdSet)
+	if err != nil {
+		return err
+	}
+	for emojiRows.Next() {
+		var image string
+		if err = emojiRows.Scan(&image); err != nil {
+			emojiRows.Close()
+			return err
+		}
+		emojiImages = append(emojiImages, image)
+	}
+	emojiRows.Close()
+
+	roleIds, err := queryIdSet(tx, "SELECT id FROM roles WHERE circle_id IN " + circleIdSet)
+	if err != nil {
+		return err
+	}
+	memberIds, err := queryIdSet(tx, "SELECT id FROM circle_members WHERE circle_id IN " + circleIdSet)
+	if err != nil {
+		return err
+	}
+	//role removals are recorded as done by whoever deleted the circle, the role history itself is kept
+	var deleter AccountId
+	row := tx.QueryRow("SELECT account_id FROM circle_deletions WHERE circle_id=?", id)
+	if err = row.Scan(&deleter); err != nil {
+		return err
+	}
+
+	statements := make([]string, 0, 16)
+	if len(roleIds) > 0 {
+		roleIdSet := idSetString(roleIds)
+		if _, err = deleteRoleMembersTx(tx, deleter, "role_id IN " + roleIdSet); err != nil {
+			return err
+		}
+		statements = append(statements,
+			"DELETE FROM role_permissions WHERE role_id IN " + roleIdSet,
+			"DELETE FROM default_subcircle_role_permissions WHERE role_id IN " + roleIdSet,
+		)
+	}
+	if len(memberIds) > 0 {
+		memberIdSet := idSetString(memberIds)
+		if _, err = deleteRoleMembersTx(tx, deleter, "circle_member_id IN " + memberIdSet); err != nil {
+			return err
+		}
+		statements = append(statements,
+			"DELETE FROM member_permissions WHERE circle_member_id IN " + memberIdSet,
+		)
+	}
+	statements = append(statements,
+		"DELETE FROM role_permissions WHERE circle_id IN " + circleIdSet,
+		"DELETE FROM default_subcircle_role_permissions WHERE circle_id IN " + circleIdSet,
+		"DELETE FROM roles WHERE circle_id IN " + circleIdSet,
+		"DELETE FROM circle_members WHERE circle_id IN " + circleIdSet,
+		"DELETE FROM reactions WHERE content_type=" + strconv.Itoa(int(COM_TYPE_POST)) + " AND content_id IN (SELECT id FROM posts WHERE circle_id IN " + circleIdSet + ")",
+		"DELETE FROM reactions WHERE content_type=" + strconv.Itoa(int(COM_TYPE_MESSAGE)) + " AND content_id IN (SELECT id FROM messages WHERE circle_id IN " + circleIdSet + ")",
+		"DELETE FROM circle_emojis WHERE circle_id IN " + circleIdSet,
+		"DELETE FROM post_revisions WHERE post_id IN (SELECT id FROM posts WHERE circle_id IN " + circleIdSet + ")",
+		"DELETE FROM posts WHERE circle_id IN " + circleIdSet,
+		"DELETE FROM message_revisions WHERE message_id IN (SELECT id FROM messages WHERE circle_id IN " + circleIdSet + ")",
+		"DELETE FROM messages WHERE circle_id IN " + circleIdSet,
+		"DELETE FROM intersections WHERE circle_id IN " + circleIdSet + " OR a_id IN " + circleIdSet + " OR b_id IN " + circleIdSet,
+		"DELETE FROM circle_invite_roles WHERE invite_id IN (SELECT id FROM circle_invites WHERE circle_id IN " + circleIdSet + ")",
+		"DELETE FROM circle_invites WHERE circle_id IN " + circleIdSet,
+		"DELETE FROM circle_bans WHERE circle_id IN " + circleIdSet,
+		"DELETE FROM circle_mutes WHERE circle_id IN " + circleIdSet,
+		"DELETE FROM circle_deletions WHERE circle_id IN " + circleIdSet,
+		"DELETE FROM circles WHERE id IN " + circleIdSet,
+	)
+	for _, statement := range statements {
+		if _, err = tx.Exec(statement); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//purges every circle whose trash period has ended, returning how many deletions were purged
+func PurgeExpiredCircles() (int, error) {
+	rows, err := MainDB.Query("SELECT circle_id FROM circle_deletions WHERE purge_after<=?", time.Now())
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return 0, nil
+		}
+		return 0, err
+	}
+	expired := make([]CircleId, 0)
+	for rows.Next() {
+		var circleId CircleId
+		if err := rows.Scan(&circleId); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		expired = append(expired, circleId)
+	}
+	rows.Close()
+
+	purged := 0
+	for _, circleId := range expired {
+		//may have already been purged along with a parent
+		var exists bool
+		row := MainDB.QueryRow("SELECT EXISTS(SELECT 1 FROM circles WHERE id=?)", circleId)
+		if err := row.Scan(&exists); err != nil {
+			return purged, err
+		} else if !exists {
+			continue
+		}
+		if err := PurgeCircle(circleId); err != nil {
+			return purged, err
+		}
+		purged++
+	}
+	return purged, nil
+}
+
+//runs PurgeExpiredCircles every CircleTrashSweepInterval, expired permission cache entries are swept on the same tick
+func StartCircleTrashSweeper() {
+	if CircleTrashSweepInterval <= 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(CircleTrashSweepInterval)
+		defer ticker.Stop()
+		for range ticker.C {
+			purged, err := PurgeExpiredCircles()
+			if err != nil {
+				App.Logger.Error(err)
+			} else if purged > 0 {
+				App.Logger.Infof("Purged %d deleted circles.", purged)
+			}
+			PermissionCache.Sweep()
+		}
+	}()
+}
+
 func InitCircles() error {
 	initJson, err := os.ReadFile(CIRCLES_INIT_DIR)
 	if err != nil {
@@ -654,7 +1598,7 @@ func InitCircles() error {
 				defaultPermissions = make(PermissionsList, len(perms))
 				for name, value := range perms {
 					if granted, ok := value.(bool); ok {
-						if perm, ok := PERMS_NAME_MAP[name]; ok {
+						if perm, ok := PermissionRegistry.LookupName(name); ok {
 							defaultPermissions[perm.Number] = granted
 						}
 					}
@@ -681,7 +1625,7 @@ func InitCircles() error {
 				if orderAny, ok := roleInfo["order"]; ok && orderAny != nil {
 					role.Order = int(orderAny.(float64))
 				} else {
-					role.Order = (1<<31) - 1
+					role.Order = ROLE_ORDER_LOWEST
 				}
 				roles = append(roles, role)
 			}
@@ -702,7 +1646,7 @@ func InitCircles() error {
 				permList := make(PermissionsList, len(permListInfo))
 				for pname, grantedAny := range permListInfo {
 					if granted, ok := grantedAny.(bool); ok {
-						if perm, ok := PERMS_NAME_MAP[pname]; ok {
+						if perm, ok := PermissionRegistry.LookupName(pname); ok {
 							permList[perm.Number] = granted
 						}
 					}
@@ -759,9 +1703,10 @@ func InitCircles() error {
 				changeNames = append(changeNames, "default_subcircle_permissions")
 				changeValues = append(changeValues, nil)
 			} else if defaultPermissions != nil {
-				existsDefaultPermList := PermissionsFromBytes(existDefaultPermissions)
+				//unreadable stored permissions are replaced with the configured ones
+				existsDefaultPermList, decodeErr := PermissionsFromBytes(existDefaultPermissions)
 				changed := false
-				if len(defaultPermissions) == len(existsDefaultPermList) {
+				if decodeErr == nil && len(defaultPermissions) == len(existsDefaultPermList) {
 					for number, granted := range existsDefaultPermList {
 						if otherGranted, ok := defaultPermissions[number]; !ok || granted != otherGranted {
 							changed = true
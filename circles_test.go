@@ -0,0 +1,131 @@
+package main
+
+import "testing"
+
+func TestCheckMoveCycle(t *testing.T) {
+	//1 is the root, 2 and 3 are under 1, 4 is under 2. 8 and 9 are parents of each other
+	parents := map[CircleId]CircleId{2: 1, 3: 1, 4: 2, 8: 9, 9: 8}
+	parentOf := func(circle CircleId) (*CircleId, error) {
+		if parent, ok := parents[circle]; ok {
+			return &parent, nil
+		}
+		return nil, nil
+	}
+
+	tests := []struct {
+		name string
+		id CircleId
+		parent CircleId
+		cycle bool
+	}{
+		{"into itself", 2, 2, true},
+		{"into its child", 2, 4, true},
+		{"root into its grandchild", 1, 4, true},
+		{"into a sibling", 2, 3, false},
+		{"into a sibling's child", 3, 4, false},
+		{"child up to the root", 4, 1, false},
+		{"into an unrelated root", 2, 5, false},
+		{"into a tree that already loops", 2, 8, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := checkMoveCycle(test.id, test.parent, parentOf)
+			if _, isCycle := err.(*CircleCycleError); isCycle != test.cycle {
+				t.Errorf("checkMoveCycle(%d, %d) = %v, want cycle %t", test.id, test.parent, err, test.cycle)
+			}
+		})
+	}
+}
+
+func TestCombineAccountPermissions(t *testing.T) {
+	const circle CircleId = 2
+	role := func(granted bool) PermissionSource {
+		return PermissionSource{Granted: granted, Reason: PERMISSION_REASON_ROLE, CircleId: circle}
+	}
+	member := func(granted bool) PermissionSource {
+		return PermissionSource{Granted: granted, Reason: PERMISSION_REASON_MEMBER, CircleId: circle}
+	}
+	admin := ResolvedPermissions{PERM_ADMINISTRATOR.Number: role(true), PERM_VIEW_CIRCLE.Number: role(false), PERM_SEND_CONTENT.Number: role(true)}
+
+	tests := []struct {
+		name string
+		inputs accountPermissionInputs
+		permission Permission
+		granted bool
+		reason string
+		inherited bool
+	}{
+		{"owner beats ban and mute", accountPermissionInputs{OwnedCircle: circle, Banned: true, Muted: true}, PERM_SEND_CONTENT, true, PERMISSION_REASON_OWNER, false},
+		{"owner of a parent", accountPermissionInputs{OwnedCircle: 1}, PERM_VIEW_CIRCLE, true, PERMISSION_REASON_OWNER, true},
+		{"ban beats roles", accountPermissionInputs{Banned: true, Roles: admin}, PERM_VIEW_CIRCLE, false, "", false},
+		{"not a member", accountPermissionInputs{Overrides: ResolvedPermissions{PERM_VIEW_CIRCLE.Number: member(true)}}, PERM_VIEW_CIRCLE, false, "", false},
+		{"role grant", accountPermissionInputs{Roles: ResolvedPermissions{PERM_VIEW_CIRCLE.Number: role(true)}}, PERM_VIEW_CIRCLE, true, PERMISSION_REASON_ROLE, false},
+		{"member deny beats role grant", accountPermissionInputs{
+			Roles: ResolvedPermissions{PERM_VIEW_CIRCLE.Number: role(true)},
+			Overrides: ResolvedPermissions{PERM_VIEW_CIRCLE.Number: member(false)},
+		}, PERM_VIEW_CIRCLE, false, PERMISSION_REASON_MEMBER, false},
+		{"member grant beats role deny", accountPermissionInputs{
+			Roles: ResolvedPermissions{PERM_VIEW_CIRCLE.Number: role(false)},
+			Overrides: ResolvedPermissions{PERM_VIEW_CIRCLE.Number: member(true)},
+		}, PERM_VIEW_CIRCLE, true, PERMISSION_REASON_MEMBER, false},
+		{"administrator beats role deny", accountPermissionInputs{Roles: admin}, PERM_VIEW_CIRCLE, true, PERMISSION_REASON_ADMINISTRATOR, false},
+		{"administrator beats member deny", accountPermissionInputs{
+			Roles: admin,
+			Overrides: ResolvedPermissions{PERM_VIEW_CIRCLE.Number: member(false)},
+		}, PERM_VIEW_CIRCLE, true, PERMISSION_REASON_ADMINISTRATOR, false},
+		{"member deny of administrator", accountPermissionInputs{
+			Roles: admin,
+			Overrides: ResolvedPermissions{PERM_ADMINISTRATOR.Number: member(false)},
+		}, PERM_VIEW_CIRCLE, false, PERMISSION_REASON_ROLE, false},
+		{"mute beats administrator", accountPermissionInputs{Roles: admin, Muted: true}, PERM_SEND_CONTENT, false, PERMISSION_REASON_MUTED, false},
+		{"mute leaves other permissions", accountPermissionInputs{Roles: admin, Muted: true}, PERM_VIEW_CIRCLE, true, PERMISSION_REASON_ADMINISTRATOR, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			source := combineAccountPermissions(circle, test.inputs)[test.permission.Number]
+			if source.Granted != test.granted || source.Reason != test.reason || source.Inherited != test.inherited {
+				t.Errorf("%s = %+v, want granted %t, reason %q, inherited %t", test.permission.Name, source, test.granted, test.reason, test.inherited)
+			}
+		})
+	}
+}
+
+func TestCombineAccountPermissionsKeepsRoles(t *testing.T) {
+	roles := ResolvedPermissions{PERM_SEND_CONTENT.Number: {Granted: true, Reason: PERMISSION_REASON_ROLE}}
+	combineAccountPermissions(1, accountPermissionInputs{Roles: roles, Muted: true})
+	if !roles[PERM_SEND_CONTENT.Number].Granted {
+		t.Error("combineAccountPermissions changed the role permissions it was given")
+	}
+}
+
+func TestResolveRolePermissionRows(t *testing.T) {
+	const permission PermissionNumber = 1
+	row := func(granted bool, depth int, order int, role RoleId) rolePermissionRow {
+		return rolePermissionRow{Permission: permission, Granted: granted, CircleId: CircleId(10 - depth), Rank: RoleRank{depth, order}, RoleId: role}
+	}
+
+	tests := []struct {
+		name string
+		rows []rolePermissionRow
+		granted bool
+		role RoleId
+		inherited bool
+	}{
+		{"higher role's deny beats grant", []rolePermissionRow{row(true, 0, 5, 1), row(false, 0, 2, 2)}, false, 2, false},
+		{"higher role's grant beats deny", []rolePermissionRow{row(false, 0, 5, 1), row(true, 0, 2, 2)}, true, 2, false},
+		{"circle beats its parent", []rolePermissionRow{row(true, 1, 1, 1), row(false, 0, ROLE_ORDER_LOWEST, 2)}, false, 2, false},
+		{"inherited from a parent", []rolePermissionRow{row(true, 2, 1, 1), row(false, 3, 1, 2)}, true, 1, true},
+		{"first row wins a tie", []rolePermissionRow{row(true, 0, 3, 1), row(false, 0, 3, 2)}, true, 1, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			source, ok := resolveRolePermissionRows(test.rows)[permission]
+			if !ok || source.Granted != test.granted || source.RoleId != test.role || source.Inherited != test.inherited {
+				t.Errorf("resolved %+v, want granted %t by role %d, inherited %t", source, test.granted, test.role, test.inherited)
+			}
+		})
+	}
+	if resolved := resolveRolePermissionRows(nil); len(resolved) != 0 {
+		t.Errorf("resolved %v from no rows, want nothing", resolved)
+	}
+}
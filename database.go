@@ -3,13 +3,17 @@ package main
 import (
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
 
-	_ "github.com/go-sql-driver/mysql"
+	"github.com/go-sql-driver/mysql"
 )
 
+//MySQL's error number for an insert or update that breaks a unique key
+const MYSQL_ER_DUP_ENTRY uint16 = 1062
+
 var (
     MainDB *sql.DB
     DB_CONFIG_PATH = filepath.Join(CONFIG_DIR, "db_config.json")
@@ -108,3 +112,13 @@ func UpdateSessionLogin(sessionId string, accountId int64) error {
     _, err = MainDB.Exec("UPDATE logins SET account_id=? WHERE session_id=?", accountId, sessionId)
     return err
 }
+
+//either MainDB or a transaction
+type sqlQueryer interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+}
+
+func isDuplicateKeyError(err error) bool {
+	var mysqlErr *mysql.MySQLError
+	return errors.As(err, &mysqlErr) && mysqlErr.Number == MYSQL_ER_DUP_ENTRY
+}
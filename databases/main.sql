@@ -21,7 +21,10 @@ CREATE TABLE IF NOT EXISTS circles (
     created DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
     com_type TINYINT NOT NULL,
     default_subcircle_com_type TINYINT,
-    default_subcircle_permissions BLOB
+    default_subcircle_permissions BLOB,
+    deleted_id BIGINT,
+    inherit_roles BOOLEAN NOT NULL DEFAULT TRUE,
+    follow_parent_membership BOOLEAN NOT NULL DEFAULT FALSE
 );
 CREATE TABLE IF NOT EXISTS intersections (
     id BIGINT NOT NULL AUTO_INCREMENT PRIMARY KEY,
@@ -34,12 +37,16 @@ CREATE TABLE IF NOT EXISTS circle_members (
     id BIGINT NOT NULL AUTO_INCREMENT PRIMARY KEY,
     account_id BIGINT NOT NULL,
     circle_id BIGINT NOT NULL,
-    joined DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+    joined DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE KEY account_circle (account_id, circle_id)
 );
 CREATE TABLE IF NOT EXISTS posts (
     id BIGINT NOT NULL AUTO_INCREMENT PRIMARY KEY,
     circle_id BIGINT NOT NULL,
+    author_id BIGINT NOT NULL,
     created DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    edited DATETIME,
+    deleted DATETIME,
     title VARCHAR(100) NOT NULL,
     body TEXT NOT NULL
 );
@@ -55,6 +62,8 @@ CREATE TABLE IF NOT EXISTS messages (
     author_id BIGINT NOT NULL,
     reply_id BIGINT,
     created DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    edited DATETIME,
+    deleted DATETIME,
     body VARCHAR(1000)
 );
 CREATE TABLE IF NOT EXISTS roles (
@@ -76,5 +85,113 @@ CREATE TABLE IF NOT EXISTS role_members (
     id BIGINT NOT NULL AUTO_INCREMENT PRIMARY KEY,
     role_id BIGINT NOT NULL,
     circle_member_id BIGINT NOT NULL,
-    joined DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+    assigned_by BIGINT,
+    joined DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE KEY role_member (role_id, circle_member_id)
+);
+CREATE TABLE IF NOT EXISTS default_subcircle_role_permissions (
+    id BIGINT NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    circle_id BIGINT NOT NULL,
+    role_id BIGINT NOT NULL,
+    permission_number BIGINT NOT NULL,
+    granted BIT NOT NULL
+);
+CREATE TABLE IF NOT EXISTS circle_deletions (
+    id BIGINT NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    circle_id BIGINT NOT NULL,
+    account_id BIGINT NOT NULL,
+    deleted DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    purge_after DATETIME NOT NULL
+);
+CREATE TABLE IF NOT EXISTS circle_invites (
+    id BIGINT NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    code VARCHAR(16) NOT NULL,
+    circle_id BIGINT NOT NULL,
+    creator_id BIGINT NOT NULL,
+    created DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    expires DATETIME,
+    max_uses INTEGER,
+    uses INTEGER NOT NULL DEFAULT 0,
+    revoked BOOLEAN NOT NULL DEFAULT FALSE,
+    UNIQUE(code)
+);
+CREATE TABLE IF NOT EXISTS circle_invite_roles (
+    id BIGINT NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    invite_id BIGINT NOT NULL,
+    role_id BIGINT NOT NULL
+);
+CREATE TABLE IF NOT EXISTS circle_bans (
+    id BIGINT NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    circle_id BIGINT NOT NULL,
+    account_id BIGINT NOT NULL,
+    issuer_id BIGINT NOT NULL,
+    reason VARCHAR(400) NOT NULL,
+    created DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    expires DATETIME
+);
+CREATE TABLE IF NOT EXISTS circle_mutes (
+    id BIGINT NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    circle_id BIGINT NOT NULL,
+    account_id BIGINT NOT NULL,
+    issuer_id BIGINT NOT NULL,
+    reason VARCHAR(400) NOT NULL,
+    created DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    expires DATETIME NOT NULL
+);
+CREATE TABLE IF NOT EXISTS role_member_history (
+    id BIGINT NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    role_id BIGINT NOT NULL,
+    circle_member_id BIGINT NOT NULL,
+    actor_id BIGINT NOT NULL,
+    added BOOLEAN NOT NULL,
+    created DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+CREATE TABLE IF NOT EXISTS member_permissions (
+    id BIGINT NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    circle_member_id BIGINT NOT NULL,
+    permission_number BIGINT NOT NULL,
+    granted BOOLEAN NOT NULL,
+    UNIQUE KEY member_permission (circle_member_id, permission_number)
+);
+CREATE TABLE IF NOT EXISTS audit_log (
+    id BIGINT NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    circle_id BIGINT NOT NULL,
+    actor_id BIGINT,
+    action VARCHAR(64) NOT NULL,
+    target_id BIGINT,
+    before_value TEXT,
+    after_value TEXT,
+    created DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+CREATE TABLE IF NOT EXISTS post_revisions (
+    id BIGINT NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    post_id BIGINT NOT NULL,
+    title VARCHAR(100) NOT NULL,
+    body TEXT NOT NULL,
+    created DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+CREATE TABLE IF NOT EXISTS message_revisions (
+    id BIGINT NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    message_id BIGINT NOT NULL,
+    body VARCHAR(1000),
+    created DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+CREATE TABLE IF NOT EXISTS circle_emojis (
+    id BIGINT NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    circle_id BIGINT NOT NULL,
+    name VARCHAR(32) NOT NULL,
+    image VARCHAR(255) NOT NULL,
+    creator_id BIGINT NOT NULL,
+    created DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE KEY circle_name (circle_id, name)
+);
+CREATE TABLE IF NOT EXISTS reactions (
+    id BIGINT NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    content_type TINYINT NOT NULL,
+    content_id BIGINT NOT NULL,
+    account_id BIGINT NOT NULL,
+    emoji VARCHAR(64) CHARACTER SET utf8mb4 COLLATE utf8mb4_bin NOT NULL DEFAULT '',
+    emoji_id BIGINT NOT NULL DEFAULT 0,
+    created DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE(content_type, content_id, account_id, emoji, emoji_id)
 );
\ No newline at end of file
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	EVENT_MESSAGE_NEW string = "message_new"
+	EVENT_MESSAGE_EDIT string = "message_edit"
+	EVENT_MESSAGE_DELETE string = "message_delete"
+	EVENT_POST_NEW string = "post_new"
+	EVENT_POST_EDIT string = "post_edit"
+	EVENT_POST_DELETE string = "post_delete"
+	EVENT_REACTION_ADD string = "reaction_add"
+	EVENT_REACTION_REMOVE string = "reaction_remove"
+	EVENT_MEMBER_JOIN string = "member_join"
+	EVENT_MEMBER_LEAVE string = "member_leave"
+
+	//how many events a subscriber can fall behind by before it's dropped
+	EVENT_BUFFER_SIZE int = 64
+	//how many published events can wait for the hub to deliver them
+	EVENT_QUEUE_SIZE int = 1024
+	EVENT_SUBSCRIPTION_MAX int = 50
+	EVENT_KEEPALIVE_INTERVAL time.Duration = 20 * time.Second
+)
+
+type CircleEvent struct {
+	Id uint64
+	Type string
+	CircleId CircleId
+	Data interface{}
+}
+
+//a stream's view of the hub. events come in on Events until the subscriber falls too far behind,
+//then Overflowed is closed and the client has to catch up through the regular endpoints
+type EventSubscriber struct {
+	AccountId AccountId
+	Events chan CircleEvent
+	circles []CircleId
+	overflowed chan struct{}
+	overflowOnce sync.Once
+}
+
+func (subscriber *EventSubscriber) Overflowed() <-chan struct{} {
+	return subscriber.overflowed
+}
+
+func (subscriber *EventSubscriber) overflow() {
+	subscriber.overflowOnce.Do(func() {
+		close(subscriber.overflowed)
+	})
+}
+
+type eventHub struct {
+	lock sync.RWMutex
+	circles map[CircleId]map[*EventSubscriber]struct{}
+	lastId atomic.Uint64
+	queue chan CircleEvent
+}
+
+var EventHub = &eventHub{
+	circles: make(map[CircleId]map[*EventSubscriber]struct{}),
+	queue: make(chan CircleEvent, EVENT_QUEUE_SIZE),
+}
+
+//delivers published events in the background, in the order they were published
+func StartEventHub() {
+	go func() {
+		for event := range EventHub.queue {
+			EventHub.deliver(event)
+		}
+	}()
+}
+
+//check for PERM_VIEW_CIRCLE in each circle before calling
+func (hub *eventHub) Subscribe(account AccountId, circles []CircleId) *EventSubscriber {
+	subscriber := &EventSubscriber{
+		AccountId: account,
+		Events: make(chan CircleEvent, EVENT_BUFFER_SIZE),
+		circles: circles,
+		overflowed: make(chan struct{}),
+	}
+
+	hub.lock.Lock()
+	defer hub.lock.Unlock()
+	for _, circle := range circles {
+		subscribers, ok := hub.circles[circle]
+		if !ok {
+			subscribers = make(map[*EventSubscriber]struct{})
+			hub.circles[circle] = subscribers
+		}
+		subscribers[subscriber] = struct{}{}
+	}
+	return subscriber
+}
+
+func (hub *eventHub) Unsubscribe(subscriber *EventSubscriber) {
+	hub.lock.Lock()
+	defer hub.lock.Unlock()
+	for _, circle := range subscriber.circles {
+		if subscribers, ok := hub.circles[circle]; ok {
+			delete(subscribers, subscriber)
+			if len(subscribers) < 1 {
+				delete(hub.circles, circle)
+			}
+		}
+	}
+}
+
+func (hub *eventHub) circleSubscribers(circle CircleId) []*EventSubscriber {
+	hub.lock.RLock()
+	defer hub.lock.RUnlock()
+	subscribers := make([]*EventSubscriber, 0, len(hub.circles[circle]))
+	for subscriber := range hub.circles[circle] {
+		subscribers = append(subscribers, subscriber)
+	}
+	return subscribers
+}
+
+//queues the event for every subscriber of the circle without waiting on delivery. if the hub has fallen
+//too far behind, the circle's subscribers are marked as overflowed instead. call after the change is committed
+func (hub *eventHub) Publish(circle CircleId, eventType string, data interface{}) {
+	event := CircleEvent{
+		Id: hub.lastId.Add(1),
+		Type: eventType,
+		CircleId: circle,
+		Data: data,
+	}
+	select {
+	case hub.queue <- event:
+	default:
+		for _, subscriber := range hub.circleSubscribers(circle) {
+			subscriber.overflow()
+		}
+	}
+}
+
+//sends the event to every subscriber of the circle that can still view it. never waits on subscribers,
+//ones with a full buffer are marked as overflowed instead
+func (hub *eventHub) deliver(event CircleEvent) {
+	//permissions are checked per event so losing access stops delivery right away, the permission cache keeps this cheap
+	for _, subscriber := range hub.circleSubscribers(event.CircleId) {
+		permissions, err := GetAccountPermissions(subscriber.AccountId, event.CircleId)
+		if err != nil {
+			App.Logger.Error(err)
+			continue
+		} else if !permissions[PERM_VIEW_CIRCLE.Number] {
+			continue
+		}
+		select {
+		case subscriber.Events <- event:
+		default:
+			subscriber.overflow()
+		}
+	}
+}
@@ -38,10 +38,20 @@ func main() {
 	} else if err = MainDB.Ping(); err != nil {
 		panic(err)
 	}
+	if err = MigrateDatabase(); err != nil {
+		panic(err)
+	}
+
+	if err = ReadSettings(); err != nil {
+		panic(err)
+	}
 
 	if err = InitCircles(); err != nil {
 		panic(err)
 	}
+	StartCircleTrashSweeper()
+	StartAuditLogSweeper()
+	StartEventHub()
 
 	err = StartServer("127.0.0.1", 8080)
 	if err != nil {
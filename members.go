@@ -0,0 +1,785 @@
+package main
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+)
+
+const (
+	INVITE_CODE_LENGTH int = 10
+	INVITE_CODE_RANGE = "0123456789abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ"
+)
+
+type AlreadyMemberError struct {
+	message string
+}
+func (err *AlreadyMemberError) Error() string {
+	return err.message
+}
+
+type InvalidInviteError struct {
+	message string
+}
+func (err *InvalidInviteError) Error() string {
+	return err.message
+}
+
+type MemberInfo struct {
+	Id MemberId
+	AccountId AccountId
+	CircleId CircleId
+	Username string
+	Joined time.Time
+}
+
+type InviteId = int64
+
+type InviteInfo struct {
+	Id InviteId
+	Code string
+	CircleId CircleId
+	CreatorId AccountId
+	Created time.Time
+	Expires *time.Time
+	MaxUses *int
+	Uses int
+	Revoked bool
+	Roles []RoleId
+}
+
+//checks if the invite can still be used at the given time
+func (invite *InviteInfo) Usable(now time.Time) bool {
+	if invite.Revoked {
+		return false
+	} else if invite.Expires != nil && !now.Before(*invite.Expires) {
+		return false
+	} else if invite.MaxUses != nil && invite.Uses >= *invite.MaxUses {
+		return false
+	}
+	return true
+}
+
+//gets the account's member id in the circle, 0 if they aren't a member
+func GetCircleMemberId(account AccountId, circle CircleId) (MemberId, error) {
+	var memberId MemberId
+	row := MainDB.QueryRow("SELECT id FROM circle_members WHERE account_id=? AND circle_id=?", account, circle)
+	if err := row.Scan(&memberId); err != nil {
+		if err == sql.ErrNoRows {
+			return 0, nil
+		}
+		return 0, err
+	}
+	return memberId, nil
+}
+
+func GetCircleMembers(circle CircleId) ([]MemberInfo, error) {
+	rows, err := MainDB.Query(
+		"SELECT m.id, m.account_id, a.username, m.joined FROM circle_members m INNER JOIN accounts a ON a.id=m.account_id WHERE m.circle_id=? ORDER BY m.joined ASC",
+		circle,
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer rows.Close()
+
+	members := make([]MemberInfo, 0)
+	for rows.Next() {
+		member := MemberInfo{CircleId: circle}
+		if err := rows.Scan(&member.Id, &member.AccountId, &member.Username, &member.Joined); err != nil {
+			return nil, err
+		}
+		members = append(members, member)
+	}
+	return members, nil
+}
+
+//actor is who added the account, which is the account itself when it joined on its own
+func joinCircleTx(tx *sql.Tx, account AccountId, circle CircleId, roles []RoleId, actor AccountId) (MemberId, error) {
+	memberId, err := addCircleMemberTx(tx, account, circle, roles, actor)
+	if err != nil {
+		return 0, err
+	}
+
+	//subcircles that follow their parent's membership are joined along with it
+	following, err := getFollowingSubcircles(tx, circle)
+	if err != nil {
+		return 0, err
+	}
+	for _, subcircle := range following {
+		if _, err := addCircleMemberTx(tx, account, subcircle, nil, actor); err != nil {
+			switch err.(type) {
+			case *AlreadyMemberError, *BannedError:
+				continue
+			}
+			return 0, err
+		}
+	}
+	return memberId, nil
+}
+
+//gets the subcircles whose membership follows the circle's, going down through subcircles that follow too
+func getFollowingSubcircles(tx *sql.Tx, circle CircleId) ([]CircleId, error) {
+	return queryIdSet(tx,
+		`WITH RECURSIVE rec AS (
+			SELECT id FROM circles WHERE parent_id=? AND follow_parent_membership=TRUE AND deleted_id IS NULL
+			UNION ALL SELECT c.id FROM circles c JOIN rec r ON c.parent_id = r.id WHERE c.follow_parent_membership=TRUE AND c.deleted_id IS NULL
+		) SELECT id FROM rec`,
+		circle,
+	)
+}
+
+func addCircleMemberTx(tx *sql.Tx, account AccountId, circle CircleId, roles []RoleId, actor AccountId) (MemberId, error) {
+	banned, _, err := getActiveRestriction(tx, "circle_bans", account, circle)
+	if err != nil {
+		return 0, err
+	} else if banned {
+		return 0, &BannedError{message: fmt.Sprintf("account %d is banned from circle %d", account, circle)}
+	}
+
+	var existingId MemberId
+	row := tx.QueryRow("SELECT id FROM circle_members WHERE account_id=? AND circle_id=?", account, circle)
+	err = row.Scan(&existingId)
+	if err == nil {
+		return existingId, &AlreadyMemberError{message: fmt.Sprintf("account %d is already a member of circle %d", account, circle)}
+	} else if err != sql.ErrNoRows {
+		return 0, err
+	}
+
+	var everyoneId RoleId
+	row = tx.QueryRow("SELECT id FROM roles WHERE circle_id=? AND name=?", circle, ROLE_NAME_EVERYONE)
+	if err := row.Scan(&everyoneId); err != nil {
+		return 0, err
+	}
+
+	//the unique key catches a concurrent join that got past the check above
+	r, err := tx.Exec("INSERT INTO circle_members (account_id, circle_id) VALUES(?, ?)", account, circle)
+	if err != nil {
+		if isDuplicateKeyError(err) {
+			return 0, &AlreadyMemberError{message: fmt.Sprintf("account %d is already a member of circle %d", account, circle)}
+		}
+		return 0, err
+	}
+	memberId, err := r.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	now := time.Now()
+	added := map[RoleId]struct{}{everyoneId: {}}
+	addedRoles := []RoleId{everyoneId}
+	if _, err := insertRoleMemberTx(tx, everyoneId, memberId, actor, now); err != nil {
+		return 0, err
+	}
+	for _, roleId := range roles {
+		if _, ok := added[roleId]; ok {
+			continue
+		}
+		if _, err := insertRoleMemberTx(tx, roleId, memberId, actor, now); err != nil {
+			return 0, err
+		}
+		added[roleId] = struct{}{}
+		addedRoles = append(addedRoles, roleId)
+	}
+
+	target := account
+	if err := writeAudit(tx, circle, &actor, AUDIT_MEMBER_JOIN, &target, nil, map[string]interface{}{"member_id": memberId, "roles": addedRoles}); err != nil {
+		return 0, err
+	}
+	return memberId, nil
+}
+
+//check for permissions before calling, the member is always given the circle's ::everyone role
+func JoinCircle(account AccountId, circle CircleId, roles []RoleId) (memberId MemberId, err error) {
+	defer InvalidatePermissionCache()
+	tx, err := MainDB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		if err == nil {
+			err = tx.Commit()
+		} else if e := tx.Rollback(); e != nil {
+			err = e
+		}
+	}()
+
+	memberId, err = joinCircleTx(tx, account, circle, roles, account)
+	return memberId, err
+}
+
+//joins the invite's circle with the invite's roles, using up one of its uses
+func JoinCircleWithInvite(account AccountId, code string) (circleId CircleId, memberId MemberId, err error) {
+	invite, err := GetInvite(code)
+	if err != nil {
+		return 0, 0, err
+	} else if invite == nil {
+		return 0, 0, &InvalidInviteError{message: fmt.Sprintf("invite %s does not exist", code)}
+	}
+
+	defer InvalidatePermissionCache()
+	tx, err := MainDB.Begin()
+	if err != nil {
+		return 0, 0, err
+	}
+	defer func() {
+		if err == nil {
+			err = tx.Commit()
+		} else if e := tx.Rollback(); e != nil {
+			err = e
+		}
+	}()
+
+	//checked in the update so concurrent joins can't go over the limit or get in after the circle is deleted
+	r, err := tx.Exec(
+		"UPDATE circle_invites SET uses=uses+1 WHERE id=? AND revoked=0 AND (expires IS NULL OR expires>?) AND (max_uses IS NULL OR uses<max_uses) AND circle_id IN (SELECT id FROM circles WHERE deleted_id IS NULL)",
+		invite.Id, time.Now(),
+	)
+	if err != nil {
+		return 0, 0, err
+	}
+	affected, err := r.RowsAffected()
+	if err != nil {
+		return 0, 0, err
+	} else if affected < 1 {
+		err = &InvalidInviteError{message: fmt.Sprintf("invite %s is no longer usable", code)}
+		return 0, 0, err
+	}
+
+	memberId, err = joinCircleTx(tx, account, invite.CircleId, invite.Roles, account)
+	return invite.CircleId, memberId, err
+}
+
+//removes the account from the circle along with all of their roles in it,
+//and from any subcircles that follow the circle's membership. the actor is the account itself when it's leaving on its own
+func LeaveCircle(account AccountId, circle CircleId, actor AccountId) (err error) {
+	memberId, err := GetCircleMemberId(account, circle)
+	if err != nil {
+		return err
+	} else if memberId == 0 {
+		return sql.ErrNoRows
+	}
+
+	defer InvalidatePermissionCache()
+	tx, err := MainDB.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err == nil {
+			err = tx.Commit()
+		} else if e := tx.Rollback(); e != nil {
+			err = e
+		}
+	}()
+
+	following, err := getFollowingSubcircles(tx, circle)
+	if err != nil {
+		return err
+	}
+	memberIds, err := queryIdSet(tx,
+		"SELECT id FROM circle_members WHERE account_id=? AND circle_id IN " + idSetString(append([]CircleId{circle}, following...)),
+		account,
+	)
+	if err != nil {
+		return err
+	} else if len(memberIds) == 0 {
+		//the membership went away after it was looked up
+		return sql.ErrNoRows
+	}
+	memberIdSet := idSetString(memberIds)
+	leftCircles, err := queryIdSet(tx, "SELECT circle_id FROM circle_members WHERE id IN " + memberIdSet)
+	if err != nil {
+		return err
+	}
+	if _, err = deleteRoleMembersTx(tx, actor, "circle_member_id IN " + memberIdSet); err != nil {
+		return err
+	}
+	for _, statement := range []string{
+		"DELETE FROM member_permissions WHERE circle_member_id IN " + memberIdSet,
+		"DELETE FROM circle_members WHERE id IN " + memberIdSet,
+	} {
+		if _, err = tx.Exec(statement); err != nil {
+			return err
+		}
+	}
+
+	action := AUDIT_MEMBER_LEAVE
+	if actor != account {
+		action = AUDIT_MEMBER_REMOVE
+	}
+	target := account
+	for _, leftCircle := range leftCircles {
+		if err = writeAudit(tx, leftCircle, &actor, action, &target, map[string]interface{}{"circle_id": circle}, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func generateInviteCode() (string, error) {
+	b := strings.Builder{}
+	b.Grow(INVITE_CODE_LENGTH)
+	max := big.NewInt(int64(len(INVITE_CODE_RANGE)))
+	for i := 0; i < INVITE_CODE_LENGTH; i++ {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		b.WriteByte(INVITE_CODE_RANGE[n.Int64()])
+	}
+	return b.String(), nil
+}
+
+//check for permissions before calling, roles should belong to the circle
+func CreateInvite(circle CircleId, creator AccountId, expires *time.Time, maxUses *int, roles []RoleId) (invite InviteInfo, err error) {
+	code, err := generateInviteCode()
+	if err != nil {
+		return invite, err
+	}
+
+	tx, err := MainDB.Begin()
+	if err != nil {
+		return invite, err
+	}
+	defer func() {
+		if err == nil {
+			err = tx.Commit()
+		} else if e := tx.Rollback(); e != nil {
+			err = e
+		}
+	}()
+
+	invite = InviteInfo{
+		Code: code,
+		CircleId: circle,
+		CreatorId: creator,
+		Created: time.Now(),
+		Expires: expires,
+		MaxUses: maxUses,
+		Roles: roles,
+	}
+	r, err := tx.Exec(
+		"INSERT INTO circle_invites (code, circle_id, creator_id, created, expires, max_uses) VALUES(?, ?, ?, ?, ?, ?)",
+		invite.Code, invite.CircleId, invite.CreatorId, invite.Created, invite.Expires, invite.MaxUses,
+	)
+	if err != nil {
+		return invite, err
+	}
+	invite.Id, err = r.LastInsertId()
+	if err != nil {
+		return invite, err
+	}
+	for _, roleId := range roles {
+		if _, err = tx.Exec("INSERT INTO circle_invite_roles (invite_id, role_id) VALUES(?, ?)", invite.Id, roleId); err != nil {
+			return invite, err
+		}
+	}
+	return invite, nil
+}
+
+func getInviteRoles(invite *InviteInfo) error {
+	rows, err := MainDB.Query("SELECT role_id FROM circle_invite_roles WHERE invite_id=?", invite.Id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil
+		}
+		return err
+	}
+	defer rows.Close()
+
+	invite.Roles = make([]RoleId, 0)
+	for rows.Next() {
+		var roleId RoleId
+		if err := rows.Scan(&roleId); err != nil {
+			return err
+		}
+		invite.Roles = append(invite.Roles, roleId)
+	}
+	return nil
+}
+
+//invites to circles in the trash are treated as missing
+func GetInvite(code string) (*InviteInfo, error) {
+	invite := &InviteInfo{Code: code}
+	row := MainDB.QueryRow(
+		"SELECT i.id, i.circle_id, i.creator_id, i.created, i.expires, i.max_uses, i.uses, i.revoked FROM circle_invites i INNER JOIN circles c ON c.id=i.circle_id WHERE i.code=? AND c.deleted_id IS NULL",
+		code,
+	)
+	if err := row.Scan(&invite.Id, &invite.CircleId, &invite.CreatorId, &invite.Created, &invite.Expires, &invite.MaxUses, &invite.Uses, &invite.Revoked); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if err := getInviteRoles(invite); err != nil {
+		return nil, err
+	}
+	return invite, nil
+}
+
+func GetCircleInvites(circle CircleId) ([]InviteInfo, error) {
+	rows, err := MainDB.Query("SELECT id, code, creator_id, created, expires, max_uses, uses, revoked FROM circle_invites WHERE circle_id=? ORDER BY created DESC", circle)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer rows.Close()
+
+	invites := make([]InviteInfo, 0)
+	for rows.Next() {
+		invite := InviteInfo{CircleId: circle}
+		if err := rows.Scan(&invite.Id, &invite.Code, &invite.CreatorId, &invite.Created, &invite.Expires, &invite.MaxUses, &invite.Uses, &invite.Revoked); err != nil {
+			return nil, err
+		}
+		invites = append(invites, invite)
+	}
+	for i := range invites {
+		if err := getInviteRoles(&invites[i]); err != nil {
+			return nil, err
+		}
+	}
+	return invites, nil
+}
+
+//check for permissions before calling
+func RevokeInvite(id InviteId) error {
+	_, err := MainDB.Exec("UPDATE circle_invites SET revoked=1 WHERE id=?", id)
+	return err
+}
+
+type BannedError struct {
+	message string
+}
+func (err *BannedError) Error() string {
+	return err.message
+}
+
+//used for both bans and mutes, Expires is nil for bans that last until they're removed
+type MemberRestriction struct {
+	Id int64
+	CircleId CircleId
+	AccountId AccountId
+	Username string
+	IssuerId AccountId
+	Reason string
+	Created time.Time
+	Expires *time.Time
+}
+
+//checks if there's an active ban for the account in the circle or any of its parents
+func IsAccountBanned(account AccountId, circle CircleId) (bool, error) {
+	banned, _, err := getActiveRestriction(MainDB, "circle_bans", account, circle)
+	return banned, err
+}
+
+//checks if there's an active mute for the account in the circle or any of its parents
+func IsAccountMuted(account AccountId, circle CircleId) (bool, error) {
+	muted, _, err := getActiveRestriction(MainDB, "circle_mutes", account, circle)
+	return muted, err
+}
+
+//checks the circle and its parents for active restrictions, also giving when the last one ends (nil if one never does)
+func getActiveRestriction(queryer sqlQueryer, table string, account AccountId, circle CircleId) (bool, *time.Time, error) {
+	rows, err := queryer.Query(
+		CIRCLE_ANCESTORS_CTE + " SELECT r.expires FROM " + table + " r INNER JOIN rec ON rec.id=r.circle_id WHERE r.account_id=? AND (r.expires IS NULL OR r.expires>?)",
+		circle, account, time.Now(),
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return false, nil, nil
+		}
+		return false, nil, err
+	}
+	defer rows.Close()
+
+	restricted := false
+	var until *time.Time = nil
+	for rows.Next() {
+		var expires *time.Time
+		if err := rows.Scan(&expires); err != nil {
+			return false, nil, err
+		}
+		if !restricted {
+			until = expires
+		} else if until != nil && (expires == nil || expires.After(*until)) {
+			until = expires
+		}
+		restricted = true
+	}
+	return restricted, until, nil
+}
+
+func getActiveRestrictions(table string, circle CircleId) ([]MemberRestriction, error) {
+	rows, err := MainDB.Query(
+		"SELECT r.id, r.account_id, a.username, r.issuer_id, r.reason, r.created, r.expires FROM " + table + " r INNER JOIN accounts a ON a.id=r.account_id WHERE r.circle_id=? AND (r.expires IS NULL OR r.expires>?) ORDER BY r.created DESC",
+		circle, time.Now(),
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer rows.Close()
+
+	restrictions := make([]MemberRestriction, 0)
+	for rows.Next() {
+		restriction := MemberRestriction{CircleId: circle}
+		if err := rows.Scan(&restriction.Id, &restriction.AccountId, &restriction.Username, &restriction.IssuerId, &restriction.Reason, &restriction.Created, &restriction.Expires); err != nil {
+			return nil, err
+		}
+		restrictions = append(restrictions, restriction)
+	}
+	return restrictions, nil
+}
+
+func GetCircleBans(circle CircleId) ([]MemberRestriction, error) {
+	return getActiveRestrictions("circle_bans", circle)
+}
+
+func GetCircleMutes(circle CircleId) ([]MemberRestriction, error) {
+	return getActiveRestrictions("circle_mutes", circle)
+}
+
+//check for permissions before calling, also removes the account from the circle and its subcircles
+func BanAccount(circle CircleId, account AccountId, issuer AccountId, reason string, expires *time.Time) (ban MemberRestriction, err error) {
+	children, err := GetAllCircleChildren(circle)
+	if err != nil {
+		return ban, err
+	}
+	circleIdSet := idSetString(append([]CircleId{circle}, children...))
+
+	defer InvalidatePermissionCache()
+	tx, err := MainDB.Begin()
+	if err != nil {
+		return ban, err
+	}
+	defer func() {
+		if err == nil {
+			err = tx.Commit()
+		} else if e := tx.Rollback(); e != nil {
+			err = e
+		}
+	}()
+
+	//replaces any existing ban
+	if _, err = tx.Exec("DELETE FROM circle_bans WHERE circle_id=? AND account_id=?", circle, account); err != nil {
+		return ban, err
+	}
+	ban = MemberRestriction{
+		CircleId: circle,
+		AccountId: account,
+		IssuerId: issuer,
+		Reason: reason,
+		Created: time.Now(),
+		Expires: expires,
+	}
+	r, err := tx.Exec(
+		"INSERT INTO circle_bans (circle_id, account_id, issuer_id, reason, created, expires) VALUES(?, ?, ?, ?, ?, ?)",
+		ban.CircleId, ban.AccountId, ban.IssuerId, ban.Reason, ban.Created, ban.Expires,
+	)
+	if err != nil {
+		return ban, err
+	}
+	ban.Id, err = r.LastInsertId()
+	if err != nil {
+		return ban, err
+	}
+
+	if _, err = deleteRoleMembersTx(tx, issuer,
+		"circle_member_id IN (SELECT id FROM circle_members WHERE account_id=? AND circle_id IN " + circleIdSet + ")",
+		account,
+	); err != nil {
+		return ban, err
+	}
+	if _, err = tx.Exec(
+		"DELETE FROM member_permissions WHERE circle_member_id IN (SELECT id FROM circle_members WHERE account_id=? AND circle_id IN " + circleIdSet + ")",
+		account,
+	); err != nil {
+		return ban, err
+	}
+	if _, err = tx.Exec("DELETE FROM circle_members WHERE account_id=? AND circle_id IN " + circleIdSet, account); err != nil {
+		return ban, err
+	}
+	target := account
+	err = writeAudit(tx, circle, &issuer, AUDIT_MEMBER_BAN, &target, nil, map[string]interface{}{"reason": reason, "expires": expires})
+	return ban, err
+}
+
+//check for permissions before calling
+func UnbanAccount(circle CircleId, account AccountId, actor AccountId) (removed bool, err error) {
+	defer InvalidatePermissionCache()
+	tx, err := MainDB.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer func() {
+		if err == nil {
+			err = tx.Commit()
+		} else if e := tx.Rollback(); e != nil {
+			err = e
+		}
+	}()
+
+	r, err := tx.Exec("DELETE FROM circle_bans WHERE circle_id=? AND account_id=?", circle, account)
+	if err != nil {
+		return false, err
+	}
+	affected, err := r.RowsAffected()
+	if err != nil || affected < 1 {
+		return false, err
+	}
+	target := account
+	err = writeAudit(tx, circle, &actor, AUDIT_MEMBER_UNBAN, &target, nil, nil)
+	return err == nil, err
+}
+
+//check for permissions before calling, replaces any existing mute
+func MuteAccount(circle CircleId, account AccountId, issuer AccountId, reason string, expires time.Time) (mute MemberRestriction, err error) {
+	defer InvalidatePermissionCache()
+	tx, err := MainDB.Begin()
+	if err != nil {
+		return mute, err
+	}
+	defer func() {
+		if err == nil {
+			err = tx.Commit()
+		} else if e := tx.Rollback(); e != nil {
+			err = e
+		}
+	}()
+
+	if _, err = tx.Exec("DELETE FROM circle_mutes WHERE circle_id=? AND account_id=?", circle, account); err != nil {
+		return mute, err
+	}
+	mute = MemberRestriction{
+		CircleId: circle,
+		AccountId: account,
+		IssuerId: issuer,
+		Reason: reason,
+		Created: time.Now(),
+		Expires: &expires,
+	}
+	r, err := tx.Exec(
+		"INSERT INTO circle_mutes (circle_id, account_id, issuer_id, reason, created, expires) VALUES(?, ?, ?, ?, ?, ?)",
+		mute.CircleId, mute.AccountId, mute.IssuerId, mute.Reason, mute.Created, expires,
+	)
+	if err != nil {
+		return mute, err
+	}
+	mute.Id, err = r.LastInsertId()
+	if err != nil {
+		return mute, err
+	}
+	target := account
+	err = writeAudit(tx, circle, &issuer, AUDIT_MEMBER_MUTE, &target, nil, map[string]interface{}{"reason": reason, "expires": expires})
+	return mute, err
+}
+
+//check for permissions before calling
+func UnmuteAccount(circle CircleId, account AccountId, actor AccountId) (removed bool, err error) {
+	defer InvalidatePermissionCache()
+	tx, err := MainDB.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer func() {
+		if err == nil {
+			err = tx.Commit()
+		} else if e := tx.Rollback(); e != nil {
+			err = e
+		}
+	}()
+
+	r, err := tx.Exec("DELETE FROM circle_mutes WHERE circle_id=? AND account_id=?", circle, account)
+	if err != nil {
+		return false, err
+	}
+	affected, err := r.RowsAffected()
+	if err != nil || affected < 1 {
+		return false, err
+	}
+	target := account
+	err = writeAudit(tx, circle, &actor, AUDIT_MEMBER_UNMUTE, &target, nil, nil)
+	return err == nil, err
+}
+
+//gets the permissions set for the member itself, these win over anything set through roles
+func GetMemberPermissions(member MemberId) (PermissionsList, error) {
+	rows, err := MainDB.Query("SELECT permission_number, granted FROM member_permissions WHERE circle_member_id=?", member)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return PermissionsList{}, nil
+		}
+		return nil, err
+	}
+	defer rows.Close()
+
+	permList := make(PermissionsList)
+	for rows.Next() {
+		var (
+			permissionNumber PermissionNumber
+			granted bool
+		)
+		if err := rows.Scan(&permissionNumber, &granted); err != nil {
+			return nil, err
+		}
+		permList[permissionNumber] = granted
+	}
+	return permList, nil
+}
+
+//check for permissions before calling, a nil value removes the override so the member's roles decide the permission
+func SetMemberPermissions(member MemberId, changes map[PermissionNumber]*bool, actor AccountId) (err error) {
+	var (
+		account AccountId
+		circle CircleId
+	)
+	row := MainDB.QueryRow("SELECT account_id, circle_id FROM circle_members WHERE id=?", member)
+	if err = row.Scan(&account, &circle); err != nil {
+		return err
+	}
+	current, err := GetMemberPermissions(member)
+	if err != nil {
+		return err
+	}
+
+	defer InvalidatePermissionCache()
+	tx, err := MainDB.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err == nil {
+			err = tx.Commit()
+		} else if e := tx.Rollback(); e != nil {
+			err = e
+		}
+	}()
+
+	for permNum, granted := range changes {
+		if granted == nil {
+			_, err = tx.Exec("DELETE FROM member_permissions WHERE circle_member_id=? AND permission_number=?", member, permNum)
+		} else {
+			_, err = tx.Exec(
+				"INSERT INTO member_permissions (circle_member_id, permission_number, granted) VALUES(?, ?, ?) ON DUPLICATE KEY UPDATE granted=VALUES(granted)",
+				member, permNum, *granted,
+			)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	before, after := auditPermissionChanges(current, changes)
+	return writeAudit(tx, circle, &actor, AUDIT_MEMBER_PERMISSIONS_UPDATE, &account, before, after)
+}
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"database/sql"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	MESSAGE_BODY_MAX_LENGTH int = 1000
+	//how much of a replied to message's body is sent along with the reply
+	MESSAGE_SNIPPET_LENGTH int = 100
+
+	MESSAGE_PAGE_SIZE_DEFAULT int = 50
+	MESSAGE_PAGE_SIZE_MAX int = 100
+)
+
+//the part of a message shown with replies to it
+type MessageSnippet struct {
+	Id MessageId
+	AuthorId AccountId
+	AuthorName *string
+	Body string
+}
+
+type MessageInfo struct {
+	Id MessageId
+	CircleId CircleId
+	AuthorId AccountId
+	AuthorName *string //nil if the author's account no longer exists
+	ReplyId *MessageId
+	Reply *MessageSnippet //nil if the message isn't a reply or the replied to message is gone
+	Created time.Time
+	Edited *time.Time //nil if the message hasn't been edited
+	Deleted *time.Time //nil unless the message was found with GetMessageIncludeDeleted
+	Body string
+}
+
+//the body a message had before an edit, Created is when the edit was made
+type MessageRevision struct {
+	Id int64
+	MessageId MessageId
+	Body string
+	Created time.Time
+}
+
+//the reply has to be in the same circle and not deleted to be shown
+const messageSelectQuery string = `SELECT m.id, m.circle_id, m.author_id, a.username, m.reply_id, m.created, m.edited, m.deleted, m.body, r.id, r.author_id, ra.username, r.body FROM messages m
+	LEFT JOIN accounts a ON a.id=m.author_id
+	LEFT JOIN messages r ON r.id=m.reply_id AND r.circle_id=m.circle_id AND r.deleted IS NULL
+	LEFT JOIN accounts ra ON ra.id=r.author_id`
+
+func scanMessage(row sqlScanner) (MessageInfo, error) {
+	var (
+		message MessageInfo
+		body *string
+		replyId *MessageId
+		replyAuthorId *AccountId
+		replyAuthorName, replyBody *string
+	)
+	err := row.Scan(
+		&message.Id, &message.CircleId, &message.AuthorId, &message.AuthorName, &message.ReplyId, &message.Created, &message.Edited, &message.Deleted, &body,
+		&replyId, &replyAuthorId, &replyAuthorName, &replyBody,
+	)
+	if err != nil {
+		return message, err
+	}
+	if body != nil {
+		message.Body = *body
+	}
+	if replyId != nil {
+		message.Reply = &MessageSnippet{Id: *replyId, AuthorId: *replyAuthorId, AuthorName: replyAuthorName}
+		if replyBody != nil {
+			message.Reply.Body = truncateRunes(*replyBody, MESSAGE_SNIPPET_LENGTH)
+		}
+	}
+	return message, nil
+}
+
+func truncateRunes(s string, length int) string {
+	if utf8.RuneCountInString(s) <= length {
+		return s
+	}
+	i, n := 0, 0
+	for i = range s {
+		if n == length {
+			break
+		}
+		n++
+	}
+	return s[:i]
+}
+
+func GetMessage(id MessageId) (*MessageInfo, error) {
+	return getMessage(id, false)
+}
+
+//same as GetMessage, but will also find deleted messages
+func GetMessageIncludeDeleted(id MessageId) (*MessageInfo, error) {
+	return getMessage(id, true)
+}
+
+func getMessage(id MessageId, includeDeleted bool) (*MessageInfo, error) {
+	queryString := messageSelectQuery + " WHERE m.id=?"
+	if !includeDeleted {
+		queryString += " AND m.deleted IS NULL"
+	}
+	message, err := scanMessage(MainDB.QueryRow(queryString, id))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &message, nil
+}
+
+func queryMessages(query string, args ...interface{}) ([]MessageInfo, error) {
+	rows, err := MainDB.Query(query, args...)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return []MessageInfo{}, nil
+		}
+		return nil, err
+	}
+	defer rows.Close()
+
+	messages := make([]MessageInfo, 0)
+	for rows.Next() {
+		message, err := scanMessage(rows)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, message)
+	}
+	return messages, nil
+}
+
+func reverseMessages(messages []MessageInfo) {
+	for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
+		messages[i], messages[j] = messages[j], messages[i]
+	}
+}
+
+//gets the newest messages, or the ones right before the message when it's given. oldest first
+func GetMessagesBefore(circle CircleId, before *MessageId, limit int) ([]MessageInfo, error) {
+	var (
+		messages []MessageInfo
+		err error
+	)
+	if before == nil {
+		messages, err = queryMessages(messageSelectQuery + " WHERE m.circle_id=? AND m.deleted IS NULL ORDER BY m.id DESC LIMIT ?", circle, limit)
+	} else {
+		messages, err = queryMessages(messageSelectQuery + " WHERE m.circle_id=? AND m.deleted IS NULL AND m.id<? ORDER BY m.id DESC LIMIT ?", circle, *before, limit)
+	}
+	if err != nil {
+		return nil, err
+	}
+	reverseMessages(messages)
+	return messages, nil
+}
+
+//gets the messages right after the message, oldest first
+func GetMessagesAfter(circle CircleId, after MessageId, limit int) ([]MessageInfo, error) {
+	return queryMessages(messageSelectQuery + " WHERE m.circle_id=? AND m.deleted IS NULL AND m.id>? ORDER BY m.id ASC LIMIT ?", circle, after, limit)
+}
+
+//gets the message along with the messages around it, about half from each side. oldest first
+func GetMessagesAround(circle CircleId, around MessageId, limit int) ([]MessageInfo, error) {
+	before, err := GetMessagesBefore(circle, &around, limit/2)
+	if err != nil {
+		return nil, err
+	}
+	after, err := queryMessages(messageSelectQuery + " WHERE m.circle_id=? AND m.deleted IS NULL AND m.id>=? ORDER BY m.id ASC LIMIT ?", circle, around, limit-len(before))
+	if err != nil {
+		return nil, err
+	}
+	return append(before, after...), nil
+}
+
+//check for permissions before calling, the reply has to be checked to be in the circle too
+func SendMessage(circle CircleId, author AccountId, body string, reply *MessageId) (*MessageInfo, error) {
+	r, err := MainDB.Exec(
+		"INSERT INTO messages (circle_id, author_id, reply_id, created, body) VALUES(?, ?, ?, ?, ?)",
+		circle, author, reply, time.Now(), body,
+	)
+	if err != nil {
+		return nil, err
+	}
+	messageId, err := r.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+	return GetMessage(messageId)
+}
+
+//check for permissions before calling, the body being replaced is kept as a revision
+func EditMessage(message *MessageInfo, body string) (err error) {
+	tx, err := MainDB.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err == nil {
+			err = tx.Commit()
+		} else if e := tx.Rollback(); e != nil {
+			err = e
+		}
+	}()
+
+	var currentBody *string
+	row := tx.QueryRow("SELECT body FROM messages WHERE id=? AND deleted IS NULL FOR UPDATE", message.Id)
+	if err = row.Scan(&currentBody); err != nil {
+		return err
+	}
+	if currentBody != nil && *currentBody == body {
+		message.Body = body
+		return nil
+	}
+
+	now := time.Now()
+	if _, err = tx.Exec("INSERT INTO message_revisions (message_id, body, created) VALUES(?, ?, ?)", message.Id, currentBody, now); err != nil {
+		return err
+	}
+	if _, err = tx.Exec("UPDATE messages SET body=?, edited=? WHERE id=?", body, now, message.Id); err != nil {
+		return err
+	}
+	message.Body, message.Edited = body, &now
+	return nil
+}
+
+//check for permissions before calling. the message is only marked as deleted so its revisions stay on record
+func DeleteMessage(id MessageId) (err error) {
+	tx, err := MainDB.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err == nil {
+			err = tx.Commit()
+		} else if e := tx.Rollback(); e != nil {
+			err = e
+		}
+	}()
+
+	if _, err = tx.Exec("DELETE FROM reactions WHERE content_type=? AND content_id=?", COM_TYPE_MESSAGE, id); err != nil {
+		return err
+	}
+	_, err = tx.Exec("UPDATE messages SET deleted=? WHERE id=? AND deleted IS NULL", time.Now(), id)
+	return err
+}
+
+//gets what the message said before each edit, oldest first
+func GetMessageRevisions(message MessageId) ([]MessageRevision, error) {
+	rows, err := MainDB.Query("SELECT id, body, created FROM message_revisions WHERE message_id=? ORDER BY id ASC", message)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer rows.Close()
+
+	revisions := make([]MessageRevision, 0)
+	for rows.Next() {
+		var (
+			revision = MessageRevision{MessageId: message}
+			body *string
+		)
+		if err := rows.Scan(&revision.Id, &body, &revision.Created); err != nil {
+			return nil, err
+		}
+		if body != nil {
+			revision.Body = *body
+		}
+		revisions = append(revisions, revision)
+	}
+	return revisions, nil
+}
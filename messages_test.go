@@ -0,0 +1,106 @@
+package main
+
+import (
+	"database/sql/driver"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+//points MainDB at a mock for the length of the test
+func mockMainDB(t *testing.T) sqlmock.Sqlmock {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous := MainDB
+	MainDB = db
+	t.Cleanup(func() {
+		MainDB = previous
+		db.Close()
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+	})
+	return mock
+}
+
+//rows for messageSelectQuery with the ids in the order given
+func messageRows(ids ...MessageId) *sqlmock.Rows {
+	rows := sqlmock.NewRows([]string{
+		"id", "circle_id", "author_id", "username", "reply_id", "created", "edited", "deleted", "body",
+		"reply_id", "reply_author_id", "reply_username", "reply_body",
+	})
+	for _, id := range ids {
+		rows.AddRow(id, 1, 1, "author", nil, time.Now(), nil, nil, "body", nil, nil, nil, nil)
+	}
+	return rows
+}
+
+func messageIds(messages []MessageInfo) []MessageId {
+	ids := make([]MessageId, len(messages))
+	for i := range messages {
+		ids[i] = messages[i].Id
+	}
+	return ids
+}
+
+func TestMessageCursors(t *testing.T) {
+	const circle CircleId = 1
+	before := MessageId(10)
+
+	type query struct {
+		pattern string
+		args []driver.Value
+		ids []MessageId
+	}
+	tests := []struct {
+		name string
+		get func() ([]MessageInfo, error)
+		queries []query
+		want []MessageId
+	}{
+		{"newest", func() ([]MessageInfo, error) { return GetMessagesBefore(circle, nil, 3) },
+			[]query{{`ORDER BY m\.id DESC LIMIT \?`, []driver.Value{circle, 3}, []MessageId{12, 11, 10}}},
+			[]MessageId{10, 11, 12}},
+		{"before", func() ([]MessageInfo, error) { return GetMessagesBefore(circle, &before, 3) },
+			[]query{{`m\.id<\? ORDER BY m\.id DESC LIMIT \?`, []driver.Value{circle, before, 3}, []MessageId{9, 8, 7}}},
+			[]MessageId{7, 8, 9}},
+		{"before the first message", func() ([]MessageInfo, error) { return GetMessagesBefore(circle, &before, 3) },
+			[]query{{`m\.id<\? ORDER BY m\.id DESC LIMIT \?`, []driver.Value{circle, before, 3}, nil}},
+			[]MessageId{}},
+		{"after", func() ([]MessageInfo, error) { return GetMessagesAfter(circle, 10, 3) },
+			[]query{{`m\.id>\? ORDER BY m\.id ASC LIMIT \?`, []driver.Value{circle, 10, 3}, []MessageId{11, 12}}},
+			[]MessageId{11, 12}},
+		{"around", func() ([]MessageInfo, error) { return GetMessagesAround(circle, 10, 5) },
+			[]query{
+				{`m\.id<\? ORDER BY m\.id DESC LIMIT \?`, []driver.Value{circle, 10, 2}, []MessageId{9, 8}},
+				{`m\.id>=\? ORDER BY m\.id ASC LIMIT \?`, []driver.Value{circle, 10, 3}, []MessageId{10, 11, 12}},
+			},
+			[]MessageId{8, 9, 10, 11, 12}},
+		//the messages missing before it are made up for after it
+		{"around near the start", func() ([]MessageInfo, error) { return GetMessagesAround(circle, 2, 5) },
+			[]query{
+				{`m\.id<\? ORDER BY m\.id DESC LIMIT \?`, []driver.Value{circle, 2, 2}, []MessageId{1}},
+				{`m\.id>=\? ORDER BY m\.id ASC LIMIT \?`, []driver.Value{circle, 2, 4}, []MessageId{2, 3, 4, 5}},
+			},
+			[]MessageId{1, 2, 3, 4, 5}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			mock := mockMainDB(t)
+			for _, q := range test.queries {
+				mock.ExpectQuery(q.pattern).WithArgs(q.args...).WillReturnRows(messageRows(q.ids...))
+			}
+			messages, err := test.get()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ids := messageIds(messages); !reflect.DeepEqual(ids, test.want) {
+				t.Errorf("got messages %v, want %v", ids, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+//a change made to the schema after its tables were first released. CREATE TABLE IF NOT EXISTS in main.sql
+//leaves existing tables alone, so databases made before the change get it applied on startup
+type schemaMigration struct {
+	description string
+	//selects whether the change has already been made
+	appliedQuery string
+	appliedArgs []interface{}
+	statements []string
+}
+
+//adds the column, backfill runs right after to fill it in for existing rows
+func addColumnMigration(table string, column string, definition string, backfill ...string) schemaMigration {
+	return schemaMigration{
+		description: "added column " + table + "." + column,
+		appliedQuery: "SELECT EXISTS(SELECT 1 FROM information_schema.COLUMNS WHERE TABLE_SCHEMA=DATABASE() AND TABLE_NAME=? AND COLUMN_NAME=?)",
+		appliedArgs: []interface{}{table, column},
+		statements: append([]string{"ALTER TABLE " + table + " ADD COLUMN " + column + " " + definition}, backfill...),
+	}
+}
+
+//adds the unique key, cleanup runs first to get rid of rows that would break it
+func addUniqueKeyMigration(table string, key string, columns string, cleanup ...string) schemaMigration {
+	return schemaMigration{
+		description: "added unique key " + table + "." + key,
+		appliedQuery: "SELECT EXISTS(SELECT 1 FROM information_schema.STATISTICS WHERE TABLE_SCHEMA=DATABASE() AND TABLE_NAME=? AND INDEX_NAME=?)",
+		appliedArgs: []interface{}{table, key},
+		statements: append(cleanup, "ALTER TABLE " + table + " ADD UNIQUE KEY " + key + " (" + columns + ")"),
+	}
+}
+
+//redefines the column with the collation, the definition has to give it
+func changeCollationMigration(table string, column string, collation string, definition string) schemaMigration {
+	return schemaMigration{
+		description: "changed collation of " + table + "." + column + " to " + collation,
+		appliedQuery: "SELECT EXISTS(SELECT 1 FROM information_schema.COLUMNS WHERE TABLE_SCHEMA=DATABASE() AND TABLE_NAME=? AND COLUMN_NAME=? AND COLLATION_NAME=?)",
+		appliedArgs: []interface{}{table, column, collation},
+		statements: []string{"ALTER TABLE " + table + " MODIFY " + column + " " + definition},
+	}
+}
+
+//in the order the changes were made, new tables still come from running main.sql
+var SCHEMA_MIGRATIONS = []schemaMigration{
+	addColumnMigration("circles", "deleted_id", "BIGINT"),
+	addColumnMigration("role_members", "assigned_by", "BIGINT"),
+	addColumnMigration("circles", "inherit_roles", "BOOLEAN NOT NULL DEFAULT TRUE"),
+	addColumnMigration("circles", "follow_parent_membership", "BOOLEAN NOT NULL DEFAULT FALSE"),
+	//posts from before authors were tracked get POST_AUTHOR_UNKNOWN, so nobody can edit them as their own
+	addColumnMigration("posts", "author_id", "BIGINT NOT NULL DEFAULT 0", "ALTER TABLE posts ALTER COLUMN author_id DROP DEFAULT"),
+	addColumnMigration("posts", "edited", "DATETIME"),
+	addColumnMigration("messages", "edited", "DATETIME"),
+	//duplicate memberships from concurrent joins are merged into the oldest one
+	addUniqueKeyMigration("circle_members", "account_circle", "account_id, circle_id",
+		"UPDATE IGNORE role_members rm INNER JOIN circle_members m ON m.id=rm.circle_member_id INNER JOIN (SELECT account_id, circle_id, MIN(id) AS id FROM circle_members GROUP BY account_id, circle_id) keep ON keep.account_id=m.account_id AND keep.circle_id=m.circle_id SET rm.circle_member_id=keep.id WHERE m.id<>keep.id",
+		"UPDATE IGNORE member_permissions mp INNER JOIN circle_members m ON m.id=mp.circle_member_id INNER JOIN (SELECT account_id, circle_id, MIN(id) AS id FROM circle_members GROUP BY account_id, circle_id) keep ON keep.account_id=m.account_id AND keep.circle_id=m.circle_id SET mp.circle_member_id=keep.id WHERE m.id<>keep.id",
+		"DELETE m FROM circle_members m INNER JOIN (SELECT account_id, circle_id, MIN(id) AS id FROM circle_members GROUP BY account_id, circle_id) keep ON keep.account_id=m.account_id AND keep.circle_id=m.circle_id WHERE m.id<>keep.id",
+		//rows the merge skipped because the kept membership already had them
+		"DELETE rm FROM role_members rm LEFT JOIN circle_members m ON m.id=rm.circle_member_id WHERE m.id IS NULL",
+		"DELETE mp FROM member_permissions mp LEFT JOIN circle_members m ON m.id=mp.circle_member_id WHERE m.id IS NULL",
+	),
+	addUniqueKeyMigration("role_members", "role_member", "role_id, circle_member_id",
+		"DELETE a FROM role_members a INNER JOIN role_members b ON b.role_id=a.role_id AND b.circle_member_id=a.circle_member_id AND b.id<a.id",
+	),
+	//the newest override is the one that was last set, so that's the one kept
+	addUniqueKeyMigration("member_permissions", "member_permission", "circle_member_id, permission_number",
+		"DELETE a FROM member_permissions a INNER JOIN member_permissions b ON b.circle_member_id=a.circle_member_id AND b.permission_number=a.permission_number AND b.id>a.id",
+	),
+	addColumnMigration("posts", "deleted", "DATETIME"),
+	addColumnMigration("messages", "deleted", "DATETIME"),
+	//later duplicates get the id added to their name so each one can still be told apart
+	addUniqueKeyMigration("circle_emojis", "circle_name", "circle_id, name",
+		"UPDATE circle_emojis a INNER JOIN circle_emojis b ON b.circle_id=a.circle_id AND b.name=a.name AND b.id<a.id SET a.name=CONCAT(LEFT(a.name, 12), '_', a.id)",
+	),
+	//emojis that only differ in ways the default collation ignores, like skin tones, are different reactions
+	changeCollationMigration("reactions", "emoji", "utf8mb4_bin", "VARCHAR(64) CHARACTER SET utf8mb4 COLLATE utf8mb4_bin NOT NULL DEFAULT ''"),
+}
+
+func MigrateDatabase() error {
+	for _, migration := range SCHEMA_MIGRATIONS {
+		var applied bool
+		row := MainDB.QueryRow(migration.appliedQuery, migration.appliedArgs...)
+		if err := row.Scan(&applied); err != nil {
+			return err
+		} else if applied {
+			continue
+		}
+
+		for _, statement := range migration.statements {
+			if _, err := MainDB.Exec(statement); err != nil {
+				return err
+			}
+		}
+		App.Logger.Infof("Migrated database: %s.", migration.description)
+	}
+	return nil
+}
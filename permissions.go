@@ -0,0 +1,306 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	UNKNOWN_PERMISSION_PREFIX string = "unknown_"
+
+	//versioned permission lists start with the magic byte, lists from before versioning start with
+	//the high byte of their uint64 count, which is always 0
+	PERMISSIONS_FORMAT_MAGIC byte = 0xc1
+	PERMISSIONS_FORMAT_VERSION byte = 1
+	//magic, version and a uint32 count
+	permissionsHeaderSize int = 6
+	permissionsChecksumSize int = 4
+)
+
+type PermissionRegistryError struct {
+	message string
+}
+func (err *PermissionRegistryError) Error() string {
+	return err.message
+}
+
+//keeps track of every permission the server knows about. numbers are what gets stored, so a permission's
+//number must never change or be reused once it's been registered by a release
+type permissionRegistry struct {
+	lock sync.RWMutex
+	byNumber map[PermissionNumber]Permission
+	byName map[string]Permission
+	groups map[string][]PermissionNumber
+}
+
+var PermissionRegistry = func() *permissionRegistry {
+	registry := &permissionRegistry{
+		byNumber: make(map[PermissionNumber]Permission, len(PERMS_ALL)),
+		byName: make(map[string]Permission, len(PERMS_ALL)),
+		groups: make(map[string][]PermissionNumber),
+	}
+	for _, p := range PERMS_ALL {
+		if err := registry.Register(p); err != nil {
+			panic(err)
+		}
+	}
+	for name, group := range map[string][]Permission{
+		"manage_subcircle": PERMS_MANAGE_SUBCIRCLE,
+		"allow_markdown": PERMS_ALLOW_MARKDOWN,
+		"manage_roles": PERMS_MANAGE_ROLES,
+		"manage_members": PERMS_MANAGE_MEMBERS,
+		"muted": PERMS_MUTED,
+	} {
+		if err := registry.RegisterGroup(name, group...); err != nil {
+			panic(err)
+		}
+	}
+	return registry
+}()
+
+//adds a permission, registering the exact same permission again is allowed
+func (registry *permissionRegistry) Register(p Permission) error {
+	if p.Number < 1 {
+		return &PermissionRegistryError{message: fmt.Sprintf("unassigned permission number: %s", p.Name)}
+	} else if len(p.Name) < 1 {
+		return &PermissionRegistryError{message: fmt.Sprintf("unnamed permission number: %d", p.Number)}
+	}
+
+	registry.lock.Lock()
+	defer registry.lock.Unlock()
+	if existing, ok := registry.byNumber[p.Number]; ok && existing != p {
+		return &PermissionRegistryError{message: fmt.Sprintf("permission number %d is already %s", p.Number, existing.Name)}
+	} else if existing, ok := registry.byName[p.Name]; ok && existing != p {
+		return &PermissionRegistryError{message: fmt.Sprintf("permission name %s is already number %d", p.Name, existing.Number)}
+	}
+	registry.byNumber[p.Number] = p
+	registry.byName[p.Name] = p
+	return nil
+}
+
+//adds or replaces a named group, its permissions have to be registered first
+func (registry *permissionRegistry) RegisterGroup(name string, permissions ...Permission) error {
+	registry.lock.Lock()
+	defer registry.lock.Unlock()
+	numbers := make([]PermissionNumber, len(permissions))
+	for i, p := range permissions {
+		if existing, ok := registry.byNumber[p.Number]; !ok || existing != p {
+			return &PermissionRegistryError{message: fmt.Sprintf("permission %s in group %s isn't registered", p.Name, name)}
+		}
+		numbers[i] = p.Number
+	}
+	registry.groups[name] = numbers
+	return nil
+}
+
+func (registry *permissionRegistry) Lookup(number PermissionNumber) (Permission, bool) {
+	registry.lock.RLock()
+	defer registry.lock.RUnlock()
+	p, ok := registry.byNumber[number]
+	return p, ok
+}
+
+//also accepts the names Get gives to unknown numbers
+func (registry *permissionRegistry) LookupName(name string) (Permission, bool) {
+	registry.lock.RLock()
+	p, ok := registry.byName[name]
+	registry.lock.RUnlock()
+	if ok {
+		return p, true
+	}
+	if strings.HasPrefix(name, UNKNOWN_PERMISSION_PREFIX) {
+		number, err := strconv.ParseInt(name[len(UNKNOWN_PERMISSION_PREFIX):], 10, 64)
+		if err == nil && number > 0 {
+			if _, known := registry.Lookup(number); !known {
+				return registry.Get(number), true
+			}
+		}
+	}
+	return Permission{}, false
+}
+
+//same as Lookup, but numbers that aren't registered (retired, or from a newer build) get a stand-in
+//so they can still be shown and passed along
+func (registry *permissionRegistry) Get(number PermissionNumber) Permission {
+	if p, ok := registry.Lookup(number); ok {
+		return p
+	}
+	return Permission{
+		Name: UNKNOWN_PERMISSION_PREFIX + strconv.FormatInt(number, 10),
+		DisplayName: fmt.Sprintf("Unknown Permission %d", number),
+		Number: number,
+	}
+}
+
+//gets every registered permission ordered by number
+func (registry *permissionRegistry) All() []Permission {
+	registry.lock.RLock()
+	all := make([]Permission, 0, len(registry.byNumber))
+	for _, p := range registry.byNumber {
+		all = append(all, p)
+	}
+	registry.lock.RUnlock()
+	sort.Slice(all, func(i, j int) bool {
+		return all[i].Number < all[j].Number
+	})
+	return all
+}
+
+func (registry *permissionRegistry) Group(name string) ([]Permission, bool) {
+	registry.lock.RLock()
+	defer registry.lock.RUnlock()
+	numbers, ok := registry.groups[name]
+	if !ok {
+		return nil, false
+	}
+	group := make([]Permission, len(numbers))
+	for i, n := range numbers {
+		group[i] = registry.byNumber[n]
+	}
+	return group, true
+}
+
+//gets the names of every group along with the names of the permissions in them
+func (registry *permissionRegistry) Groups() map[string][]string {
+	registry.lock.RLock()
+	defer registry.lock.RUnlock()
+	groups := make(map[string][]string, len(registry.groups))
+	for name, numbers := range registry.groups {
+		names := make([]string, len(numbers))
+		for i, n := range numbers {
+			names[i] = registry.byNumber[n].Name
+		}
+		groups[name] = names
+	}
+	return groups
+}
+
+type PermissionsFormatError struct {
+	message string
+}
+func (err *PermissionsFormatError) Error() string {
+	return err.message
+}
+
+/*
+version 1 layout, all big endian:
+	magic byte, version byte, uint32 count
+	count grant bits, packed from the lowest bit of each byte
+	count uint64 permission numbers in ascending order
+	uint32 crc32 (IEEE) of everything before it
+*/
+func PermissionsToBytes(list PermissionsList) []byte {
+	numbers := make([]PermissionNumber, 0, len(list))
+	for permissionNumber := range list {
+		numbers = append(numbers, permissionNumber)
+	}
+	sort.Slice(numbers, func(i, j int) bool {
+		return numbers[i] < numbers[j]
+	})
+
+	length := len(numbers)
+	grantBitsCount := (length + (8 - 1)) / 8
+	b := make([]byte, permissionsHeaderSize + grantBitsCount + length * 8 + permissionsChecksumSize)
+	b[0] = PERMISSIONS_FORMAT_MAGIC
+	b[1] = PERMISSIONS_FORMAT_VERSION
+	binary.BigEndian.PutUint32(b[2:], uint32(length))
+	cursor := permissionsHeaderSize + grantBitsCount
+	for i, permissionNumber := range numbers {
+		if list[permissionNumber] {
+			b[permissionsHeaderSize + i / 8] |= uint8(1 << (i % 8))
+		}
+		binary.BigEndian.PutUint64(b[cursor:], uint64(permissionNumber))
+		cursor += 8
+	}
+	binary.BigEndian.PutUint32(b[cursor:], crc32.ChecksumIEEE(b[:cursor]))
+	return b
+}
+
+//reads lists in any version, including the unversioned layout. empty data gives a nil list
+func PermissionsFromBytes(data []byte) (PermissionsList, error) {
+	if len(data) < 1 {
+		return nil, nil
+	} else if data[0] == 0 {
+		return permissionsFromBytesUnversioned(data)
+	} else if data[0] != PERMISSIONS_FORMAT_MAGIC {
+		return nil, &PermissionsFormatError{message: fmt.Sprintf("unrecognized permission list marker 0x%02x", data[0])}
+	} else if len(data) < permissionsHeaderSize + permissionsChecksumSize {
+		return nil, &PermissionsFormatError{message: fmt.Sprintf("permission list is too short: %d bytes", len(data))}
+	}
+
+	switch version := data[1]; version {
+	case 1:
+		body := data[:len(data) - permissionsChecksumSize]
+		if checksum := binary.BigEndian.Uint32(data[len(body):]); checksum != crc32.ChecksumIEEE(body) {
+			return nil, &PermissionsFormatError{message: "permission list checksum doesn't match"}
+		}
+		length := uint64(binary.BigEndian.Uint32(data[2:]))
+		return readPermissionEntries(body[permissionsHeaderSize:], length)
+	default:
+		return nil, &PermissionsFormatError{message: fmt.Sprintf("unsupported permission list version %d", version)}
+	}
+}
+
+//the layout used before versioning: a uint64 count, then the grant bits and numbers as in version 1, with no checksum
+func permissionsFromBytesUnversioned(data []byte) (PermissionsList, error) {
+	if len(data) < 8 {
+		return nil, &PermissionsFormatError{message: fmt.Sprintf("permission list is too short: %d bytes", len(data))}
+	}
+	length := binary.BigEndian.Uint64(data)
+	if length == 0 {
+		return nil, nil
+	}
+	return readPermissionEntries(data[8:], length)
+}
+
+//reads the grant bits and numbers for length permissions, data has to be exactly that long
+func readPermissionEntries(data []byte, length uint64) (PermissionsList, error) {
+	//checked against the data before multiplying so a corrupt count can't overflow
+	if length > uint64(len(data)) / 8 {
+		return nil, &PermissionsFormatError{message: fmt.Sprintf("permission list of %d bytes can't hold %d permissions", len(data), length)}
+	}
+	grantBitsCount := (length + (8 - 1)) / 8
+	if expected := grantBitsCount + length * 8; uint64(len(data)) != expected {
+		return nil, &PermissionsFormatError{message: fmt.Sprintf("permission list should be %d bytes but is %d", expected, len(data))}
+	}
+
+	list := make(PermissionsList, length)
+	cursor := grantBitsCount
+	for i := uint64(0); i < length; i++ {
+		permissionNumber := int64(binary.BigEndian.Uint64(data[cursor:]))
+		cursor += 8
+		list[permissionNumber] = data[i / 8] & uint8(1 << (i % 8)) != 0
+	}
+	return list, nil
+}
+
+//writes the list as an object of permission names, unknown numbers use the names Get gives them
+func PermissionsToJSON(list PermissionsList) ([]byte, error) {
+	named := make(map[string]bool, len(list))
+	for permissionNumber, granted := range list {
+		named[PermissionRegistry.Get(permissionNumber).Name] = granted
+	}
+	return json.Marshal(named)
+}
+
+func PermissionsFromJSON(data []byte) (PermissionsList, error) {
+	named := make(map[string]bool)
+	if err := json.Unmarshal(data, &named); err != nil {
+		return nil, err
+	}
+	list := make(PermissionsList, len(named))
+	for name, granted := range named {
+		p, ok := PermissionRegistry.LookupName(name)
+		if !ok {
+			return nil, &PermissionsFormatError{message: "unknown permission name: " + name}
+		}
+		list[p.Number] = granted
+	}
+	return list, nil
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+	"reflect"
+	"testing"
+)
+
+func TestPermissionsFromBytes(t *testing.T) {
+	list := PermissionsList{1: true, 30: false, 70: true}
+	valid := PermissionsToBytes(list)
+	//copies the data, changes it and fixes up the checksum so only the change is wrong
+	modified := func(change func(b []byte)) []byte {
+		b := append([]byte{}, valid...)
+		change(b)
+		body := b[:len(b) - permissionsChecksumSize]
+		binary.BigEndian.PutUint32(b[len(body):], crc32.ChecksumIEEE(body))
+		return b
+	}
+	//the same list in the layout from before versioning
+	unversioned := make([]byte, 8 + 1 + 3 * 8)
+	binary.BigEndian.PutUint64(unversioned, 3)
+	unversioned[8] = 0b101
+	for i, n := range []uint64{1, 30, 70} {
+		binary.BigEndian.PutUint64(unversioned[9 + i * 8:], n)
+	}
+
+	tests := []struct {
+		name string
+		data []byte
+		want PermissionsList
+		invalid bool
+	}{
+		{"round trip", valid, list, false},
+		{"empty", nil, nil, false},
+		{"empty list", PermissionsToBytes(PermissionsList{}), PermissionsList{}, false},
+		{"unversioned", unversioned, list, false},
+		{"unversioned empty", make([]byte, 8), nil, false},
+		{"truncated header", valid[:3], nil, true},
+		{"truncated checksum", valid[:len(valid) - 1], nil, true},
+		{"truncated entries", modified(func(b []byte) { binary.BigEndian.PutUint32(b[2:], 4) }), nil, true},
+		{"unversioned truncated", unversioned[:len(unversioned) - 1], nil, true},
+		{"unversioned count too large", append([]byte{0, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}, unversioned[8:]...), nil, true},
+		{"bad checksum", append(append([]byte{}, valid[:len(valid) - 1]...), valid[len(valid) - 1] ^ 0xff), nil, true},
+		{"changed body", append(append([]byte{}, valid[:permissionsHeaderSize]...), append([]byte{valid[permissionsHeaderSize] ^ 1}, valid[permissionsHeaderSize + 1:]...)...), nil, true},
+		{"unknown version", modified(func(b []byte) { b[1] = PERMISSIONS_FORMAT_VERSION + 1 }), nil, true},
+		{"unknown marker", modified(func(b []byte) { b[0] = 0x7f }), nil, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := PermissionsFromBytes(test.data)
+			if test.invalid {
+				if _, ok := err.(*PermissionsFormatError); !ok {
+					t.Errorf("PermissionsFromBytes(%x) = %v, %v, want a PermissionsFormatError", test.data, got, err)
+				}
+			} else if err != nil || !reflect.DeepEqual(got, test.want) {
+				t.Errorf("PermissionsFromBytes(%x) = %v, %v, want %v", test.data, got, err, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"database/sql"
+	"time"
+)
+
+const (
+	POST_TITLE_MAX_LENGTH int = 100
+	POST_BODY_MAX_LENGTH int = 65535
+
+	POST_PAGE_SIZE_DEFAULT int = 25
+	POST_PAGE_SIZE_MAX int = 100
+
+	//author_id of posts made before authors were tracked, no account has it
+	POST_AUTHOR_UNKNOWN AccountId = 0
+)
+
+type PostInfo struct {
+	Id PostId
+	CircleId CircleId
+	AuthorId AccountId //POST_AUTHOR_UNKNOWN if the post is older than author tracking
+	AuthorName *string //nil if the author's account no longer exists or isn't known
+	Created time.Time
+	Edited *time.Time //nil if the post hasn't been edited
+	Deleted *time.Time //nil unless the post was found with GetPostIncludeDeleted
+	Title string
+	Body string
+}
+
+//the content a post had before an edit, Created is when the edit was made
+type PostRevision struct {
+	Id int64
+	PostId PostId
+	Title string
+	Body string
+	Created time.Time
+}
+
+//a row or rows being scanned
+type sqlScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+const postSelectQuery string = "SELECT p.id, p.circle_id, p.author_id, a.username, p.created, p.edited, p.deleted, p.title, p.body FROM posts p LEFT JOIN accounts a ON a.id=p.author_id"
+
+func scanPost(row sqlScanner) (PostInfo, error) {
+	var post PostInfo
+	err := row.Scan(&post.Id, &post.CircleId, &post.AuthorId, &post.AuthorName, &post.Created, &post.Edited, &post.Deleted, &post.Title, &post.Body)
+	return post, err
+}
+
+func GetPost(id PostId) (*PostInfo, error) {
+	return getPost(id, false)
+}
+
+//same as GetPost, but will also find deleted posts
+func GetPostIncludeDeleted(id PostId) (*PostInfo, error) {
+	return getPost(id, true)
+}
+
+func getPost(id PostId, includeDeleted bool) (*PostInfo, error) {
+	queryString := postSelectQuery + " WHERE p.id=?"
+	if !includeDeleted {
+		queryString += " AND p.deleted IS NULL"
+	}
+	post, err := scanPost(MainDB.QueryRow(queryString, id))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &post, nil
+}
+
+//gets posts newest first. ids only go up, so paging by the last id seen stays stable while posts are added
+func GetCirclePosts(circle CircleId, before *PostId, limit int) ([]PostInfo, error) {
+	var (
+		rows *sql.Rows
+		err error
+	)
+	if before == nil {
+		rows, err = MainDB.Query(postSelectQuery + " WHERE p.circle_id=? AND p.deleted IS NULL ORDER BY p.id DESC LIMIT ?", circle, limit)
+	} else {
+		rows, err = MainDB.Query(postSelectQuery + " WHERE p.circle_id=? AND p.deleted IS NULL AND p.id<? ORDER BY p.id DESC LIMIT ?", circle, *before, limit)
+	}
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer rows.Close()
+
+	posts := make([]PostInfo, 0)
+	for rows.Next() {
+		post, err := scanPost(rows)
+		if err != nil {
+			return nil, err
+		}
+		posts = append(posts, post)
+	}
+	return posts, nil
+}
+
+//check for permissions before calling
+func CreatePost(circle CircleId, author AccountId, title string, body string) (PostInfo, error) {
+	post := PostInfo{
+		CircleId: circle,
+		AuthorId: author,
+		Created: time.Now(),
+		Title: title,
+		Body: body,
+	}
+	r, err := MainDB.Exec(
+		"INSERT INTO posts (circle_id, author_id, created, title, body) VALUES(?, ?, ?, ?, ?)",
+		post.CircleId, post.AuthorId, post.Created, post.Title, post.Body,
+	)
+	if err != nil {
+		return post, err
+	}
+	post.Id, err = r.LastInsertId()
+	return post, err
+}
+
+//check for permissions before calling, nil values are left unchanged. the content being replaced is kept as a revision
+func EditPost(post *PostInfo, title *string, body *string) (err error) {
+	tx, err := MainDB.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err == nil {
+			err = tx.Commit()
+		} else if e := tx.Rollback(); e != nil {
+			err = e
+		}
+	}()
+
+	var currentTitle, currentBody string
+	row := tx.QueryRow("SELECT title, body FROM posts WHERE id=? AND deleted IS NULL FOR UPDATE", post.Id)
+	if err = row.Scan(&currentTitle, &currentBody); err != nil {
+		return err
+	}
+	newTitle, newBody := currentTitle, currentBody
+	if title != nil {
+		newTitle = *title
+	}
+	if body != nil {
+		newBody = *body
+	}
+	if newTitle == currentTitle && newBody == currentBody {
+		post.Title, post.Body = currentTitle, currentBody
+		return nil
+	}
+
+	now := time.Now()
+	if _, err = tx.Exec("INSERT INTO post_revisions (post_id, title, body, created) VALUES(?, ?, ?, ?)", post.Id, currentTitle, currentBody, now); err != nil {
+		return err
+	}
+	if _, err = tx.Exec("UPDATE posts SET title=?, body=?, edited=? WHERE id=?", newTitle, newBody, now, post.Id); err != nil {
+		return err
+	}
+	post.Title, post.Body, post.Edited = newTitle, newBody, &now
+	return nil
+}
+
+//gets what the post looked like before each edit, oldest first
+func GetPostRevisions(post PostId) ([]PostRevision, error) {
+	rows, err := MainDB.Query("SELECT id, title, body, created FROM post_revisions WHERE post_id=? ORDER BY id ASC", post)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer rows.Close()
+
+	revisions := make([]PostRevision, 0)
+	for rows.Next() {
+		revision := PostRevision{PostId: post}
+		if err := rows.Scan(&revision.Id, &revision.Title, &revision.Body, &revision.Created); err != nil {
+			return nil, err
+		}
+		revisions = append(revisions, revision)
+	}
+	return revisions, nil
+}
+
+//check for permissions before calling. the post is only marked as deleted so its revisions stay on record
+func DeletePost(id PostId) (err error) {
+	tx, err := MainDB.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err == nil {
+			err = tx.Commit()
+		} else if e := tx.Rollback(); e != nil {
+			err = e
+		}
+	}()
+
+	if _, err = tx.Exec("DELETE FROM reactions WHERE content_type=? AND content_id=?", COM_TYPE_POST, id); err != nil {
+		return err
+	}
+	_, err = tx.Exec("UPDATE posts SET deleted=? WHERE id=? AND deleted IS NULL", time.Now(), id)
+	return err
+}
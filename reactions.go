@@ -0,0 +1,291 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	EMOJI_NAME_MAX_LENGTH int = 32
+	//longest unicode emoji sequence accepted, in bytes. family and flag sequences get close to this
+	EMOJI_MAX_LENGTH int = 64
+
+	REACTORS_PAGE_SIZE_DEFAULT int = 50
+	REACTORS_PAGE_SIZE_MAX int = 100
+)
+
+//image types custom emojis can be uploaded as, by file extension
+var EMOJI_IMAGE_TYPES = map[string]string{
+	"png": "image/png",
+	"gif": "image/gif",
+	"webp": "image/webp",
+	"jpg": "image/jpeg",
+	"jpeg": "image/jpeg",
+}
+
+type DuplicateEmojiNameError struct {
+	message string
+}
+func (err *DuplicateEmojiNameError) Error() string {
+	return err.message
+}
+
+//what's being reacted to, the content type is the communication type of the circle it's in
+type ReactionTarget struct {
+	ContentType CommunicationType
+	ContentId int64
+}
+
+//either a unicode emoji or one of a circle's custom emojis, the other field is left empty
+type ReactionEmoji struct {
+	Emoji string
+	EmojiId EmojiId
+}
+
+type ReactionCount struct {
+	ReactionEmoji
+	Count int
+	Reacted bool //whether the account asking has this reaction
+}
+
+type Reactor struct {
+	Id int64
+	AccountId AccountId
+	Username *string //nil if the account no longer exists
+	Created time.Time
+}
+
+type CustomEmoji struct {
+	Id EmojiId
+	CircleId CircleId
+	Name string
+	Image string //name of the file in the media directory
+	CreatorId AccountId
+	Created time.Time
+}
+
+//checks that the string is a single emoji, including sequences joined with ZWJ, keycaps, flags and skin tones
+func IsUnicodeEmoji(s string) bool {
+	if len(s) < 1 || len(s) > EMOJI_MAX_LENGTH || !utf8.ValidString(s) {
+		return false
+	}
+	hasSymbol := false
+	for _, r := range s {
+		switch {
+		case unicode.Is(unicode.So, r), r == '\u20e3':
+			hasSymbol = true
+		case r >= 0x1f3fb && r <= 0x1f3ff:
+			//skin tone modifiers
+		case r == '\u200d', r == '\ufe0e', r == '\ufe0f':
+			//joiners and presentation selectors
+		case r >= 0xe0020 && r <= 0xe007f:
+			//tags, used by subdivision flags
+		case r == '#', r == '*', r >= '0' && r <= '9':
+			//keycap bases, only count with the keycap mark
+		default:
+			return false
+		}
+	}
+	return hasSymbol
+}
+
+func scanCustomEmoji(row sqlScanner) (CustomEmoji, error) {
+	var emoji CustomEmoji
+	err := row.Scan(&emoji.Id, &emoji.CircleId, &emoji.Name, &emoji.Image, &emoji.CreatorId, &emoji.Created)
+	return emoji, err
+}
+
+func GetCustomEmoji(id EmojiId) (*CustomEmoji, error) {
+	emoji, err := scanCustomEmoji(MainDB.QueryRow("SELECT id, circle_id, name, image, creator_id, created FROM circle_emojis WHERE id=?", id))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &emoji, nil
+}
+
+//gets the custom emojis that can be used in the circle, which includes the ones from its parents. nearest circle first
+func GetUsableCustomEmojis(circle CircleId) ([]CustomEmoji, error) {
+	rows, err := MainDB.Query(
+		CIRCLE_ANCESTORS_CTE + " SELECT e.id, e.circle_id, e.name, e.image, e.creator_id, e.created FROM circle_emojis e INNER JOIN rec ON rec.id=e.circle_id ORDER BY rec.depth ASC, e.name ASC",
+		circle,
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer rows.Close()
+
+	emojis := make([]CustomEmoji, 0)
+	for rows.Next() {
+		emoji, err := scanCustomEmoji(rows)
+		if err != nil {
+			return nil, err
+		}
+		emojis = append(emojis, emoji)
+	}
+	return emojis, nil
+}
+
+//checks whether the emoji belongs to the circle or one of its parents
+func IsCustomEmojiUsable(emoji EmojiId, circle CircleId) (bool, error) {
+	var usable bool
+	row := MainDB.QueryRow(CIRCLE_ANCESTORS_CTE + " SELECT EXISTS(SELECT 1 FROM circle_emojis e INNER JOIN rec ON rec.id=e.circle_id WHERE e.id=?)", circle, emoji)
+	if err := row.Scan(&usable); err != nil {
+		return false, err
+	}
+	return usable, nil
+}
+
+//check for permissions before calling, names only have to be unique within the circle
+func CreateCustomEmoji(circle CircleId, name string, image string, creator AccountId) (CustomEmoji, error) {
+	emoji := CustomEmoji{
+		CircleId: circle,
+		Name: name,
+		Image: image,
+		CreatorId: creator,
+		Created: time.Now(),
+	}
+	var exists bool
+	row := MainDB.QueryRow("SELECT EXISTS(SELECT 1 FROM circle_emojis WHERE circle_id=? AND name=?)", circle, name)
+	if err := row.Scan(&exists); err != nil {
+		return emoji, err
+	} else if exists {
+		return emoji, &DuplicateEmojiNameError{message: fmt.Sprintf("duplicate emoji name %s for circle %d", name, circle)}
+	}
+
+	//the unique key catches a concurrent create that got past the check above
+	r, err := MainDB.Exec(
+		"INSERT INTO circle_emojis (circle_id, name, image, creator_id, created) VALUES(?, ?, ?, ?, ?)",
+		emoji.CircleId, emoji.Name, emoji.Image, emoji.CreatorId, emoji.Created,
+	)
+	if err != nil {
+		if isDuplicateKeyError(err) {
+			return emoji, &DuplicateEmojiNameError{message: fmt.Sprintf("duplicate emoji name %s for circle %d", name, circle)}
+		}
+		return emoji, err
+	}
+	emoji.Id, err = r.LastInsertId()
+	return emoji, err
+}
+
+//check for permissions before calling, also removes every reaction using the emoji
+func DeleteCustomEmoji(id EmojiId) (err error) {
+	tx, err := MainDB.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err == nil {
+			err = tx.Commit()
+		} else if e := tx.Rollback(); e != nil {
+			err = e
+		}
+	}()
+
+	if _, err = tx.Exec("DELETE FROM reactions WHERE emoji_id=?", id); err != nil {
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM circle_emojis WHERE id=?", id)
+	return err
+}
+
+//gets how many accounts used each reaction on the content, in the order the reactions were first used
+func GetReactionCounts(target ReactionTarget, viewer AccountId) ([]ReactionCount, error) {
+	rows, err := MainDB.Query(
+		"SELECT emoji, emoji_id, COUNT(*), MAX(account_id=?) FROM reactions WHERE content_type=? AND content_id=? GROUP BY emoji, emoji_id ORDER BY MIN(id) ASC",
+		viewer, target.ContentType, target.ContentId,
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := make([]ReactionCount, 0)
+	for rows.Next() {
+		var count ReactionCount
+		if err := rows.Scan(&count.Emoji, &count.EmojiId, &count.Count, &count.Reacted); err != nil {
+			return nil, err
+		}
+		counts = append(counts, count)
+	}
+	return counts, nil
+}
+
+//checks whether anyone has used the reaction on the content yet
+func HasReaction(target ReactionTarget, emoji ReactionEmoji) (bool, error) {
+	var exists bool
+	row := MainDB.QueryRow(
+		"SELECT EXISTS(SELECT 1 FROM reactions WHERE content_type=? AND content_id=? AND emoji=? AND emoji_id=?)",
+		target.ContentType, target.ContentId, emoji.Emoji, emoji.EmojiId,
+	)
+	if err := row.Scan(&exists); err != nil {
+		return false, err
+	}
+	return exists, nil
+}
+
+//gets who used the reaction, oldest first. after is a reactor id to page forwards from
+func GetReactors(target ReactionTarget, emoji ReactionEmoji, after *int64, limit int) ([]Reactor, error) {
+	var afterId int64 = 0
+	if after != nil {
+		afterId = *after
+	}
+	rows, err := MainDB.Query(
+		"SELECT r.id, r.account_id, a.username, r.created FROM reactions r LEFT JOIN accounts a ON a.id=r.account_id WHERE r.content_type=? AND r.content_id=? AND r.emoji=? AND r.emoji_id=? AND r.id>? ORDER BY r.id ASC LIMIT ?",
+		target.ContentType, target.ContentId, emoji.Emoji, emoji.EmojiId, afterId, limit,
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer rows.Close()
+
+	reactors := make([]Reactor, 0)
+	for rows.Next() {
+		var reactor Reactor
+		if err := rows.Scan(&reactor.Id, &reactor.AccountId, &reactor.Username, &reactor.Created); err != nil {
+			return nil, err
+		}
+		reactors = append(reactors, reactor)
+	}
+	return reactors, nil
+}
+
+//check for permissions before calling, returns false if the account already had the reaction
+func AddReaction(target ReactionTarget, account AccountId, emoji ReactionEmoji) (bool, error) {
+	//the unique key skips the insert when the reaction is already there, even with concurrent requests
+	r, err := MainDB.Exec(
+		"INSERT IGNORE INTO reactions (content_type, content_id, account_id, emoji, emoji_id, created) VALUES(?, ?, ?, ?, ?, ?)",
+		target.ContentType, target.ContentId, account, emoji.Emoji, emoji.EmojiId, time.Now(),
+	)
+	if err != nil {
+		return false, err
+	}
+	affected, err := r.RowsAffected()
+	return affected > 0, err
+}
+
+func RemoveReaction(target ReactionTarget, account AccountId, emoji ReactionEmoji) (bool, error) {
+	r, err := MainDB.Exec(
+		"DELETE FROM reactions WHERE content_type=? AND content_id=? AND account_id=? AND emoji=? AND emoji_id=?",
+		target.ContentType, target.ContentId, account, emoji.Emoji, emoji.EmojiId,
+	)
+	if err != nil {
+		return false, err
+	}
+	affected, err := r.RowsAffected()
+	return affected > 0, err
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestIsUnicodeEmoji(t *testing.T) {
+	tests := []struct {
+		name string
+		s string
+		emoji bool
+	}{
+		{"single", "😀", true},
+		{"symbol", "❤", true},
+		{"presentation selector", "❤️", true},
+		{"skin tone", "👍🏽", true},
+		{"zwj sequence", "👩‍💻", true},
+		{"family", "👨‍👩‍👧‍👦", true},
+		{"flag", "🇯🇵", true},
+		{"subdivision flag", "🏴󠁧󠁢󠁳󠁣󠁴󠁿", true},
+		{"keycap", "1️⃣", true},
+		{"empty", "", false},
+		{"letter", "a", false},
+		{"word", "smile", false},
+		{"emoji with text", "😀a", false},
+		{"digit without keycap", "1", false},
+		{"skin tone alone", "🏽", false},
+		{"joiner alone", "‍", false},
+		{"invalid utf-8", "\xf0\x9f\x98", false},
+		{"too long", strings.Repeat("😀", EMOJI_MAX_LENGTH/4 + 1), false},
+		{"space", "😀 😀", false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if emoji := IsUnicodeEmoji(test.s); emoji != test.emoji {
+				t.Errorf("IsUnicodeEmoji(%q) = %t, want %t", test.s, emoji, test.emoji)
+			}
+		})
+	}
+}
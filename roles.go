@@ -0,0 +1,507 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+)
+
+type RoleHierarchyError struct {
+	message string
+}
+func (err *RoleHierarchyError) Error() string {
+	return err.message
+}
+
+func GetCircleRoles(circle CircleId) ([]RoleInfo, error) {
+	rows, err := MainDB.Query("SELECT id, priority_order, name, color FROM roles WHERE circle_id=? ORDER BY priority_order ASC", circle)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer rows.Close()
+
+	roles := make([]RoleInfo, 0)
+	for rows.Next() {
+		var (
+			roleInfo = RoleInfo{CircleId: circle}
+			color []byte
+		)
+		if err := rows.Scan(&roleInfo.Id, &roleInfo.Order, &roleInfo.Name, &color); err != nil {
+			return nil, err
+		}
+		if color != nil {
+			roleInfo.Color = color
+		} else {
+			roleInfo.Color = append([]uint8(nil), DEFAULT_ROLE_COLOR...)
+		}
+		roles = append(roles, roleInfo)
+	}
+	return roles, nil
+}
+
+//where a role stands when ranks from different circles are compared. a role in a nearer circle ranks above every
+//role in a further one, the order ResolvePermissions decides permissions in, and within a circle the lower
+//priority_order ranks higher
+type RoleRank struct {
+	Depth int //how many circles above the one being checked the role is, -1 for owners
+	Order int
+}
+
+var (
+	//owners of the circle or any of its parents rank above every role
+	ROLE_RANK_OWNER = RoleRank{Depth: -1, Order: ROLE_ORDER_OWNER}
+	//accounts with only ::everyone roles rank here, every member has one so they don't set anyone apart.
+	//permissions set for ::everyone still rank by their circle in ResolvePermissions
+	ROLE_RANK_LOWEST = RoleRank{Depth: math.MaxInt32, Order: ROLE_ORDER_LOWEST}
+)
+
+func (rank RoleRank) Above(other RoleRank) bool {
+	if rank.Depth != other.Depth {
+		return rank.Depth < other.Depth
+	}
+	return rank.Order < other.Order
+}
+
+//the role's rank in its own circle
+func (role RoleInfo) Rank() RoleRank {
+	if role.Order >= ROLE_ORDER_LOWEST {
+		return ROLE_RANK_LOWEST
+	}
+	return RoleRank{Depth: 0, Order: role.Order}
+}
+
+//gets the rank of the account's highest role in the circle, counting roles inherited from parents the same way
+//permissions do
+func GetAccountTopRoleRank(account AccountId, circle CircleId) (RoleRank, error) {
+	ownedId, err := GetOwnedCircle(account, circle)
+	if err != nil {
+		return ROLE_RANK_LOWEST, err
+	} else if ownedId != 0 {
+		return ROLE_RANK_OWNER, nil
+	}
+
+	top := RoleRank{}
+	row := MainDB.QueryRow(
+		CIRCLE_ANCESTORS_CTE + ` SELECT rec.depth, r.priority_order FROM rec
+			INNER JOIN circle_members m ON m.circle_id=rec.id INNER JOIN role_members rm ON rm.circle_member_id=m.id INNER JOIN roles r ON r.id=rm.role_id
+			WHERE m.account_id=? AND r.priority_order<? AND ` + INHERITED_ROLES_CONDITION + `
+			ORDER BY rec.depth ASC, r.priority_order ASC LIMIT 1`,
+		circle, account, ROLE_ORDER_LOWEST,
+	)
+	if err := row.Scan(&top.Depth, &top.Order); err != nil {
+		if err == sql.ErrNoRows {
+			return ROLE_RANK_LOWEST, nil
+		}
+		return ROLE_RANK_LOWEST, err
+	}
+	return top, nil
+}
+
+//checks that the account's highest role ranks above the given rank
+func CanManageRoleRank(account AccountId, circle CircleId, rank RoleRank) (bool, error) {
+	top, err := GetAccountTopRoleRank(account, circle)
+	if err != nil {
+		return false, err
+	}
+	return top.Above(rank), nil
+}
+
+func findRoleByName(circle CircleId, name string) (RoleId, error) {
+	var roleId RoleId
+	row := MainDB.QueryRow("SELECT id FROM roles WHERE circle_id=? AND name=?", circle, name)
+	if err := row.Scan(&roleId); err != nil {
+		if err == sql.ErrNoRows {
+			return 0, nil
+		}
+		return 0, err
+	}
+	return roleId, nil
+}
+
+//check for permissions before calling
+func CreateRole(role RoleInfo, actor AccountId) (roleId RoleId, err error) {
+	defer InvalidatePermissionCache()
+	existingId, err := findRoleByName(role.CircleId, role.Name)
+	if err != nil {
+		return 0, err
+	} else if existingId != 0 {
+		return existingId, &DuplicateRoleNameError{message: fmt.Sprintf("duplicate role name %s for circle %d", role.Name, role.CircleId)}
+	}
+
+	tx, err := MainDB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		if err == nil {
+			err = tx.Commit()
+		} else if e := tx.Rollback(); e != nil {
+			err = e
+		}
+	}()
+
+	r, err := tx.Exec(
+		"INSERT INTO roles (circle_id, priority_order, name, color, created) VALUES(?, ?, ?, ?, ?)",
+		role.CircleId, role.Order, role.Name, role.Color, time.Now(),
+	)
+	if err != nil {
+		return 0, err
+	}
+	roleId, err = r.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	err = writeAudit(tx, role.CircleId, &actor, AUDIT_ROLE_CREATE, &roleId, nil, auditRole(&role))
+	return roleId, err
+}
+
+//check for permissions before calling, nil values are left unchanged
+func UpdateRole(role *RoleInfo, name *string, color []byte, actor AccountId) (err error) {
+	if name == nil && color == nil {
+		return nil
+	}
+	//resolved permissions keep the role's name
+	defer InvalidatePermissionCache()
+	before := auditRole(role)
+	if name != nil && *name != role.Name {
+		existingId, err := findRoleByName(role.CircleId, *name)
+		if err != nil {
+			return err
+		} else if existingId != 0 {
+			return &DuplicateRoleNameError{message: fmt.Sprintf("duplicate role name %s for circle %d", *name, role.CircleId)}
+		}
+	}
+
+	tx, err := MainDB.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err == nil {
+			err = tx.Commit()
+		} else if e := tx.Rollback(); e != nil {
+			err = e
+		}
+	}()
+
+	if name != nil && *name != role.Name {
+		if _, err = tx.Exec("UPDATE roles SET name=? WHERE id=?", *name, role.Id); err != nil {
+			return err
+		}
+		role.Name = *name
+	}
+	if color != nil {
+		if _, err = tx.Exec("UPDATE roles SET color=? WHERE id=?", color, role.Id); err != nil {
+			return err
+		}
+		role.Color = color
+	}
+	return writeAudit(tx, role.CircleId, &actor, AUDIT_ROLE_UPDATE, &role.Id, before, auditRole(role))
+}
+
+//check for permissions before calling, removes the role from every member and permission list it's in
+func DeleteRole(role RoleId, actor AccountId) (err error) {
+	info, err := GetRoleInfo(role)
+	if err != nil {
+		return err
+	} else if info == nil {
+		return sql.ErrNoRows
+	}
+
+	defer InvalidatePermissionCache()
+	tx, err := MainDB.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err == nil {
+			err = tx.Commit()
+		} else if e := tx.Rollback(); e != nil {
+			err = e
+		}
+	}()
+
+	//the role's history is kept so it's still known who had it
+	if _, err = deleteRoleMembersTx(tx, actor, "role_id=?", role); err != nil {
+		return err
+	}
+	statements := []string{
+		"DELETE FROM role_permissions WHERE role_id=?",
+		"DELETE FROM default_subcircle_role_permissions WHERE role_id=?",
+		"DELETE FROM circle_invite_roles WHERE role_id=?",
+		"DELETE FROM roles WHERE id=?",
+	}
+	for _, statement := range statements {
+		if _, err = tx.Exec(statement, role); err != nil {
+			return err
+		}
+	}
+	return writeAudit(tx, info.CircleId, &actor, AUDIT_ROLE_DELETE, &role, auditRole(info), nil)
+}
+
+//check for permissions before calling, roles are given from highest to lowest and
+//are rearranged within the priority_order values they already had
+func ReorderRoles(circle CircleId, roles []RoleId, actor AccountId) (err error) {
+	if len(roles) < 1 {
+		return nil
+	}
+	rows, err := MainDB.Query("SELECT id, priority_order FROM roles WHERE circle_id=? AND id IN " + idSetString(roles), circle)
+	if err != nil {
+		return err
+	}
+	orders := make([]int, 0, len(roles))
+	previous := make(map[RoleId]int, len(roles))
+	for rows.Next() {
+		var (
+			roleId RoleId
+			order int
+		)
+		if err = rows.Scan(&roleId, &order); err != nil {
+			rows.Close()
+			return err
+		}
+		orders = append(orders, order)
+		previous[roleId] = order
+	}
+	rows.Close()
+	if len(orders) != len(roles) {
+		return &RoleHierarchyError{message: fmt.Sprintf("not all roles belong to circle %d", circle)}
+	}
+	sort.Ints(orders)
+
+	defer InvalidatePermissionCache()
+	tx, err := MainDB.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err == nil {
+			err = tx.Commit()
+		} else if e := tx.Rollback(); e != nil {
+			err = e
+		}
+	}()
+
+	reordered := make(map[RoleId]int, len(roles))
+	for i, roleId := range roles {
+		if _, err = tx.Exec("UPDATE roles SET priority_order=? WHERE id=?", orders[i], roleId); err != nil {
+			return err
+		}
+		reordered[roleId] = orders[i]
+	}
+	return writeAudit(tx, circle, &actor, AUDIT_ROLE_REORDER, nil, previous, reordered)
+}
+
+type RoleMemberInfo struct {
+	MemberId MemberId
+	AccountId AccountId
+	Username string
+	AssignedBy *AccountId
+	Joined time.Time
+}
+
+func GetRoleMembers(role RoleId) ([]RoleMemberInfo, error) {
+	rows, err := MainDB.Query(
+		"SELECT m.id, m.account_id, a.username, rm.assigned_by, rm.joined FROM role_members rm INNER JOIN circle_members m ON m.id=rm.circle_member_id INNER JOIN accounts a ON a.id=m.account_id WHERE rm.role_id=? ORDER BY rm.joined ASC",
+		role,
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer rows.Close()
+
+	members := make([]RoleMemberInfo, 0)
+	for rows.Next() {
+		var member RoleMemberInfo
+		if err := rows.Scan(&member.MemberId, &member.AccountId, &member.Username, &member.AssignedBy, &member.Joined); err != nil {
+			return nil, err
+		}
+		members = append(members, member)
+	}
+	return members, nil
+}
+
+//check for permissions before calling, members that already have the role are skipped
+func AddRoleMembers(role RoleId, members []MemberId, actor AccountId) (added []MemberId, err error) {
+	info, err := GetRoleInfo(role)
+	if err != nil {
+		return nil, err
+	} else if info == nil {
+		return nil, sql.ErrNoRows
+	}
+
+	defer InvalidatePermissionCache()
+	tx, err := MainDB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err == nil {
+			err = tx.Commit()
+		} else if e := tx.Rollback(); e != nil {
+			err = e
+		}
+	}()
+
+	now := time.Now()
+	added = make([]MemberId, 0, len(members))
+	for _, memberId := range members {
+		var inserted bool
+		if inserted, err = insertRoleMemberTx(tx, role, memberId, actor, now); err != nil {
+			return nil, err
+		} else if !inserted {
+			continue
+		}
+		if err = writeRoleMemberAudit(tx, info, memberId, actor, AUDIT_ROLE_MEMBER_ADD); err != nil {
+			return nil, err
+		}
+		added = append(added, memberId)
+	}
+	return added, nil
+}
+
+//check for permissions before calling
+func RemoveRoleMember(role RoleId, member MemberId, actor AccountId) (removed bool, err error) {
+	info, err := GetRoleInfo(role)
+	if err != nil {
+		return false, err
+	} else if info == nil {
+		return false, sql.ErrNoRows
+	}
+
+	defer InvalidatePermissionCache()
+	tx, err := MainDB.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer func() {
+		if err == nil {
+			err = tx.Commit()
+		} else if e := tx.Rollback(); e != nil {
+			err = e
+		}
+	}()
+
+	affected, err := deleteRoleMembersTx(tx, actor, "role_id=? AND circle_member_id=?", role, member)
+	if err != nil || affected < 1 {
+		return false, err
+	}
+	err = writeRoleMemberAudit(tx, info, member, actor, AUDIT_ROLE_MEMBER_REMOVE)
+	return err == nil, err
+}
+
+//gives the member the role, recording who assigned it in role_member_history
+//false if the member already had it
+func insertRoleMemberTx(tx *sql.Tx, role RoleId, member MemberId, actor AccountId, now time.Time) (bool, error) {
+	r, err := tx.Exec("INSERT IGNORE INTO role_members (role_id, circle_member_id, assigned_by, joined) VALUES(?, ?, ?, ?)", role, member, actor, now)
+	if err != nil {
+		return false, err
+	}
+	affected, err := r.RowsAffected()
+	if err != nil || affected < 1 {
+		return false, err
+	}
+	_, err = tx.Exec("INSERT INTO role_member_history (role_id, circle_member_id, actor_id, added, created) VALUES(?, ?, ?, TRUE, ?)", role, member, actor, now)
+	return err == nil, err
+}
+
+//removes the role_members rows matching the condition, recording each removal in role_member_history first
+//returns how many were removed
+func deleteRoleMembersTx(tx *sql.Tx, actor AccountId, condition string, args ...interface{}) (int64, error) {
+	historyArgs := append([]interface{}{actor, time.Now()}, args...)
+	if _, err := tx.Exec(
+		"INSERT INTO role_member_history (role_id, circle_member_id, actor_id, added, created) SELECT role_id, circle_member_id, ?, FALSE, ? FROM role_members WHERE " + condition,
+		historyArgs...,
+	); err != nil {
+		return 0, err
+	}
+	r, err := tx.Exec("DELETE FROM role_members WHERE " + condition, args...)
+	if err != nil {
+		return 0, err
+	}
+	return r.RowsAffected()
+}
+
+//role member entries target the member's account so they show up when filtering by it
+func writeRoleMemberAudit(tx *sql.Tx, role *RoleInfo, member MemberId, actor AccountId, action string) error {
+	var account AccountId
+	row := tx.QueryRow("SELECT account_id FROM circle_members WHERE id=?", member)
+	if err := row.Scan(&account); err != nil {
+		return err
+	}
+	values := map[string]interface{}{"role_id": role.Id, "role_name": role.Name, "member_id": member}
+	if action == AUDIT_ROLE_MEMBER_ADD {
+		return writeAudit(tx, role.CircleId, &actor, action, &account, nil, values)
+	}
+	return writeAudit(tx, role.CircleId, &actor, action, &account, values, nil)
+}
+
+//gets the permissions explicitly set for the role in the circle, anything missing is inherited
+func GetRolePermissions(role RoleId, circle CircleId) (PermissionsList, error) {
+	rows, err := MainDB.Query("SELECT permission_number, granted FROM role_permissions WHERE role_id=? AND circle_id=?", role, circle)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return PermissionsList{}, nil
+		}
+		return nil, err
+	}
+	defer rows.Close()
+
+	permList := make(PermissionsList)
+	for rows.Next() {
+		var (
+			permissionNumber PermissionNumber
+			granted bool
+		)
+		if err := rows.Scan(&permissionNumber, &granted); err != nil {
+			return nil, err
+		}
+		permList[permissionNumber] = granted
+	}
+	return permList, nil
+}
+
+//check for permissions before calling, a nil value removes the permission so it's inherited from parent circles
+func SetRolePermissions(role RoleId, circle CircleId, changes map[PermissionNumber]*bool, actor AccountId) (err error) {
+	current, err := GetRolePermissions(role, circle)
+	if err != nil {
+		return err
+	}
+
+	defer InvalidatePermissionCache()
+	tx, err := MainDB.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err == nil {
+			err = tx.Commit()
+		} else if e := tx.Rollback(); e != nil {
+			err = e
+		}
+	}()
+
+	for permNum, granted := range changes {
+		if _, err = tx.Exec("DELETE FROM role_permissions WHERE role_id=? AND circle_id=? AND permission_number=?", role, circle, permNum); err != nil {
+			return err
+		}
+		if granted == nil {
+			continue
+		}
+		_, err = tx.Exec("INSERT INTO role_permissions (role_id, circle_id, permission_number, granted) VALUES(?, ?, ?, ?)", role, circle, permNum, *granted)
+		if err != nil {
+			return err
+		}
+	}
+
+	before, after := auditPermissionChanges(current, changes)
+	return writeAudit(tx, circle, &actor, AUDIT_ROLE_PERMISSIONS_UPDATE, &role, before, after)
+}
@@ -0,0 +1,39 @@
+package main
+
+import "testing"
+
+func TestRoleRankAbove(t *testing.T) {
+	tests := []struct {
+		name string
+		rank RoleRank
+		other RoleRank
+		above bool
+	}{
+		{"lower order in the same circle", RoleRank{0, 1}, RoleRank{0, 2}, true},
+		{"higher order in the same circle", RoleRank{0, 2}, RoleRank{0, 1}, false},
+		{"same rank", RoleRank{1, 3}, RoleRank{1, 3}, false},
+		{"nearer circle with a higher order", RoleRank{0, 50}, RoleRank{1, 1}, true},
+		{"further circle with a lower order", RoleRank{2, 1}, RoleRank{1, 50}, false},
+		{"owner above the top role", ROLE_RANK_OWNER, RoleRank{0, ROLE_ORDER_OWNER + 1}, true},
+		{"role above an owner", RoleRank{0, ROLE_ORDER_OWNER + 1}, ROLE_RANK_OWNER, false},
+		{"owner above another owner", ROLE_RANK_OWNER, ROLE_RANK_OWNER, false},
+		{"far role above the lowest", RoleRank{40, ROLE_ORDER_LOWEST - 1}, ROLE_RANK_LOWEST, true},
+		{"lowest above the lowest", ROLE_RANK_LOWEST, ROLE_RANK_LOWEST, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if above := test.rank.Above(test.other); above != test.above {
+				t.Errorf("%+v.Above(%+v) = %t, want %t", test.rank, test.other, above, test.above)
+			}
+		})
+	}
+}
+
+func TestRoleInfoRank(t *testing.T) {
+	if rank := (RoleInfo{Order: 5}).Rank(); rank != (RoleRank{0, 5}) {
+		t.Errorf("rank of a role = %+v, want %+v", rank, RoleRank{0, 5})
+	}
+	if rank := (RoleInfo{Name: ROLE_NAME_EVERYONE, Order: ROLE_ORDER_LOWEST}).Rank(); rank != ROLE_RANK_LOWEST {
+		t.Errorf("rank of %s = %+v, want %+v", ROLE_NAME_EVERYONE, rank, ROLE_RANK_LOWEST)
+	}
+}
@@ -531,6 +531,7 @@ func BindRoutes() error {
 	App.GET("/signup", RouteSignup)
 	App.POST("/signup", RouteSignupPost)
 	App.POST("/logout", RouteLogout)
+	BindApiRoutes()
 	return nil
 }
 
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+var (
+	SETTINGS_PATH = filepath.Join(CONFIG_DIR, "settings.json")
+
+	//how long deleted circles can be restored for
+	CircleTrashPeriod time.Duration = 7 * 24 * time.Hour
+	//how often the trash is checked for circles to purge
+	CircleTrashSweepInterval time.Duration = time.Hour
+	//how long resolved permissions are cached for, 0 turns the cache off
+	PermissionCacheTTL time.Duration = 5 * time.Minute
+	//how long audit log entries are kept for, 0 keeps them forever
+	AuditLogRetention time.Duration = 90 * 24 * time.Hour
+	//how often the audit log is checked for entries to remove
+	AuditLogSweepInterval time.Duration = time.Hour
+)
+
+type Settings map[string]interface{}
+
+//reads a number of seconds from the settings, keeping the fallback if it isn't there
+func (s Settings) Seconds(key string, fallback time.Duration) time.Duration {
+	if seconds, ok := s[key].(float64); ok && seconds >= 0 {
+		return time.Duration(seconds * float64(time.Second))
+	}
+	return fallback
+}
+
+//settings are optional, the defaults are kept if the file doesn't exist
+func ReadSettings() error {
+	byteValue, err := os.ReadFile(SETTINGS_PATH)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	settings := make(Settings)
+	if err := json.Unmarshal(byteValue, &settings); err != nil {
+		return err
+	}
+
+	CircleTrashPeriod = settings.Seconds("circle_trash_period", CircleTrashPeriod)
+	CircleTrashSweepInterval = settings.Seconds("circle_trash_sweep_interval", CircleTrashSweepInterval)
+	PermissionCacheTTL = settings.Seconds("permission_cache_ttl", PermissionCacheTTL)
+	AuditLogRetention = settings.Seconds("audit_log_retention", AuditLogRetention)
+	AuditLogSweepInterval = settings.Seconds("audit_log_sweep_interval", AuditLogSweepInterval)
+	return nil
+}